@@ -0,0 +1,91 @@
+package obj
+
+import (
+	"go/constant"
+	"go/token"
+	"reflect"
+)
+
+// encodeConstant and decodeConstant serialize a go/constant.Value as a
+// one-byte kind tag plus a kind-specific payload. Int goes through
+// ExactString/MakeFromLiteral directly, since its decimal form is
+// always a valid INT literal. Float and Complex don't have that
+// property --- ExactString renders a non-terminating rational Float as
+// "a/b" and a Complex as "a+bi", neither of which token.FLOAT or
+// token.IMAG can parse back --- so they're instead decomposed into
+// their Int-valued numerator/denominator (and, for Complex, real/imag)
+// parts, each of which *does* have a valid literal form, and
+// reassembled with constant.BinaryOp on the way back in. This mirrors
+// how the compiler's own export data stores non-integer constants.
+const (
+	constKindBool = iota
+	constKindString
+	constKindInt
+	constKindFloat
+	constKindComplex
+)
+
+// encodeRat encodes v (an Int or Float constant.Value) as its
+// numerator and denominator, each rendered as a decimal INT literal.
+func encodeRat(v constant.Value) []byte {
+	num := constant.Num(v)
+	denom := constant.Denom(v)
+	return encodeBytes([]byte(num.ExactString()), []byte(denom.ExactString()))
+}
+
+// decodeRat reverses encodeRat, reconstructing the exact rational
+// value via Float division of the two parts rather than integer
+// division, so a non-integral ratio doesn't get truncated to zero.
+func decodeRat(data []byte) constant.Value {
+	pos := 0
+	num := readField(data, &pos)
+	denom := readField(data, &pos)
+	numF := constant.ToFloat(constant.MakeFromLiteral(string(num), token.INT, 0))
+	denomF := constant.ToFloat(constant.MakeFromLiteral(string(denom), token.INT, 0))
+	return constant.BinaryOp(numF, token.QUO, denomF)
+}
+
+func encodeConstant(v reflect.Value) (byte, []byte) {
+	val := v.Interface().(constant.Value)
+	switch val.Kind() {
+	case constant.Bool:
+		if constant.BoolVal(val) {
+			return constKindBool, []byte{1}
+		}
+		return constKindBool, []byte{0}
+	case constant.String:
+		return constKindString, []byte(constant.StringVal(val))
+	case constant.Int:
+		return constKindInt, []byte(val.ExactString())
+	case constant.Float:
+		return constKindFloat, encodeRat(val)
+	case constant.Complex:
+		re := encodeRat(constant.Real(val))
+		im := encodeRat(constant.Imag(val))
+		return constKindComplex, encodeBytes(re, im)
+	default:
+		panic(val)
+	}
+}
+
+func decodeConstant(kind byte, data []byte) constant.Value {
+	switch kind {
+	case constKindBool:
+		return constant.MakeBool(len(data) > 0 && data[0] == 1)
+	case constKindString:
+		return constant.MakeString(string(data))
+	case constKindInt:
+		return constant.MakeFromLiteral(string(data), token.INT, 0)
+	case constKindFloat:
+		return decodeRat(data)
+	case constKindComplex:
+		pos := 0
+		reBlob := readField(data, &pos)
+		imBlob := readField(data, &pos)
+		re := decodeRat(reBlob)
+		im := decodeRat(imBlob)
+		return constant.BinaryOp(re, token.ADD, constant.MakeImag(im))
+	default:
+		panic(kind)
+	}
+}
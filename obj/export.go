@@ -0,0 +1,434 @@
+package obj
+
+import (
+	"bytes"
+	"encoding/binary"
+	"go/types"
+	"reflect"
+)
+
+// This file implements the writer half of the graph's indexed export
+// format, modeled after the compiler's iexport: a package is encoded
+// once as a single blob with four sections --- a string table, a
+// dense type-data section, a dense object section, and a name ->
+// object index --- so that a reader can load the index cheaply and
+// only decode the objects it actually needs.
+
+const exportVersion = 1
+
+const (
+	kindFunc = iota
+	kindVar
+	kindTypename
+	kindConst
+	kindPkgname
+
+	kindSignature
+	kindNamed
+	kindSlice
+	kindPointer
+	kindInterface
+	kindArray
+	kindStruct
+	kindTuple
+	kindMap
+	kindChan
+
+	// kindTypeParamName marks an object entry for a *types.TypeName
+	// that names a type parameter rather than an ordinary named
+	// type. It's kept distinct from kindTypename so the decoder
+	// knows to rebuild the parameter (and its constraint) before
+	// anything tries to instantiate the generic type or function
+	// that binds it.
+	kindTypeParamName
+
+	kindTypeParam // type kind: *types.TypeParam
+	kindUnion     // type kind: *types.Union (a type set constraint)
+	kindTerm      // tags one term (tilde bit + type) inside a kindUnion entry
+)
+
+// typeRef tags describe how a type is referenced from within an
+// entry: either inline (a dense index into this package's type
+// section), as a named type belonging to another package (looked up
+// lazily through the Graph's Importer), or as a universe-scope named
+// type (error, comparable, and friends), looked up by name in
+// types.Universe so every package shares the very same type object
+// instead of each minting its own.
+const (
+	refBuiltin = iota
+	refLocal
+	refForeign
+	refUniverse
+)
+
+type exportWriter struct {
+	g   *Graph
+	pkg *types.Package
+
+	strs    map[string]int
+	strList []string
+
+	typIdx map[types.Type]int
+	typBuf [][]byte // entry bodies, indexed by dense type index
+
+	objIdx map[types.Object]int
+	objBuf [][]byte // entry bodies, indexed by dense object index
+
+	names []string // exported top-level names, in scope order
+}
+
+func newExportWriter(g *Graph, pkg *types.Package) *exportWriter {
+	return &exportWriter{
+		g:      g,
+		pkg:    pkg,
+		strs:   map[string]int{},
+		typIdx: map[types.Type]int{},
+		objIdx: map[types.Object]int{},
+	}
+}
+
+// newPackageWriter builds an exportWriter with every package-level
+// object of pkg already interned, ready for encodeInfo (to pull in
+// whatever extra objects/types types.Info references) followed by
+// finish (to serialize the combined result).
+func newPackageWriter(g *Graph, pkg *types.Package) *exportWriter {
+	w := newExportWriter(g, pkg)
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		w.objectIndex(obj)
+		w.names = append(w.names, name)
+	}
+	return w
+}
+
+func (w *exportWriter) stringIndex(s string) int {
+	if i, ok := w.strs[s]; ok {
+		return i
+	}
+	i := len(w.strList)
+	w.strs[s] = i
+	w.strList = append(w.strList, s)
+	return i
+}
+
+// objectIndex ensures obj is encoded in this package's object
+// section and returns its dense index. obj must belong to w.pkg.
+func (w *exportWriter) objectIndex(obj types.Object) int {
+	if i, ok := w.objIdx[obj]; ok {
+		return i
+	}
+	i := len(w.objBuf)
+	w.objIdx[obj] = i
+	w.objBuf = append(w.objBuf, nil) // reserve the slot before recursing, for cycles
+	w.objBuf[i] = w.encodeObject(obj)
+	w.g.internObj(w.pkg.Path(), i, obj)
+	return i
+}
+
+// typeIndex ensures T is encoded in this package's type section and
+// returns its dense index. T must not be a *types.Named belonging to
+// a different package; use writeTypeRef for general references.
+func (w *exportWriter) typeIndex(T types.Type) int {
+	if i, ok := w.typIdx[T]; ok {
+		return i
+	}
+	i := len(w.typBuf)
+	w.typIdx[T] = i
+	w.typBuf = append(w.typBuf, nil) // reserve the slot before recursing, for cycles
+	w.typBuf[i] = w.encodeType(T)
+	w.g.internTyp(w.pkg.Path(), i, T)
+	return i
+}
+
+// writeTypeRef encodes a reference to T, inlining it into this
+// package's type section if it's local (or unnamed), and emitting a
+// foreign reference (package path, object name) if T is a Named type
+// that belongs to a different package.
+func (w *exportWriter) writeTypeRef(T types.Type) []byte {
+	if basic, ok := T.(*types.Basic); ok {
+		return encodeBytes([]byte{refBuiltin}, []byte(basic.Name()))
+	}
+	if named, ok := T.(*types.Named); ok && named.Obj().Pkg() == nil {
+		// A universe-scope named type (error, comparable, ...) has no
+		// owning package; encode it by name alone so the decoder can
+		// resolve it back to the canonical types.Universe object
+		// instead of minting a new, non-identical one.
+		return encodeBytes([]byte{refUniverse}, []byte(named.Obj().Name()))
+	}
+	if named, ok := T.(*types.Named); ok && named.Obj().Pkg() != nil && named.Obj().Pkg() != w.pkg {
+		obj := named.Obj()
+		return encodeBytes(
+			[]byte{refForeign},
+			uvarintBytes(uint64(w.stringIndex(obj.Pkg().Path()))),
+			uvarintBytes(uint64(w.stringIndex(obj.Name()))),
+		)
+	}
+	idx := w.typeIndex(T)
+	return encodeBytes([]byte{refLocal}, uvarintBytes(uint64(idx)))
+}
+
+// writeTypeParamRefs encodes a (possibly nil) type parameter list as
+// a count followed by that many type references, each resolving to
+// a *types.TypeParam entry.
+func (w *exportWriter) writeTypeParamRefs(tp *types.TypeParamList) []byte {
+	n := 0
+	if tp != nil {
+		n = tp.Len()
+	}
+	args := [][]byte{uvarintBytes(uint64(n))}
+	for i := 0; i < n; i++ {
+		args = append(args, w.writeTypeRef(tp.At(i)))
+	}
+	return encodeBytes(args...)
+}
+
+// writeObjRef encodes a reference to an object (used for
+// receivers, struct fields, etc. that always live in the same
+// package as the enclosing type, so this is really just a thin
+// wrapper that keeps encodeObject/encodeType symmetrical).
+func (w *exportWriter) writeObjRef(obj types.Object) []byte {
+	return uvarintBytes(uint64(w.objectIndex(obj)))
+}
+
+func (w *exportWriter) encodeObject(obj types.Object) []byte {
+	name := uvarintBytes(uint64(w.stringIndex(obj.Name())))
+
+	var kind byte
+	switch obj := obj.(type) {
+	case *types.Func:
+		kind = kindFunc
+	case *types.Var:
+		kind = kindVar
+	case *types.TypeName:
+		if _, ok := obj.Type().(*types.TypeParam); ok {
+			kind = kindTypeParamName
+		} else {
+			kind = kindTypename
+		}
+	case *types.Const:
+		kind = kindConst
+	case *types.PkgName:
+		kind = kindPkgname
+	default:
+		panic(name)
+	}
+
+	typRef := w.writeTypeRef(obj.Type())
+
+	switch obj := obj.(type) {
+	case *types.PkgName:
+		return encodeBytes(
+			name,
+			[]byte{kind},
+			typRef,
+			[]byte(obj.Imported().Path()),
+		)
+	case *types.Const:
+		ckind, data := encodeConstant(reflect.ValueOf(obj.Val()))
+		return encodeBytes(
+			name,
+			[]byte{kind},
+			typRef,
+			[]byte{ckind},
+			data,
+		)
+	default:
+		return encodeBytes(
+			name,
+			[]byte{kind},
+			typRef,
+		)
+	}
+}
+
+func (w *exportWriter) encodeType(T types.Type) []byte {
+	switch T := T.(type) {
+	case *types.Signature:
+		params := w.writeTypeRef(T.Params())
+		results := w.writeTypeRef(T.Results())
+		var recv []byte
+		if T.Recv() != nil {
+			recv = w.writeObjRef(T.Recv())
+		}
+		variadic := byte(0)
+		if T.Variadic() {
+			variadic = 1
+		}
+		hasRecv := byte(0)
+		if T.Recv() != nil {
+			hasRecv = 1
+		}
+		return encodeBytes(
+			[]byte{kindSignature},
+			params,
+			results,
+			[]byte{hasRecv},
+			recv,
+			[]byte{variadic},
+			w.writeTypeParamRefs(T.RecvTypeParams()),
+			w.writeTypeParamRefs(T.TypeParams()),
+		)
+	case *types.Named:
+		var args [][]byte
+		args = append(args, []byte{kindNamed})
+
+		if targs := T.TypeArgs(); targs != nil && targs.Len() > 0 {
+			// T is an instantiation of a generic named type: don't
+			// re-derive its underlying/methods, just remember the
+			// origin and the type arguments so the decoder can
+			// recreate it with types.Instantiate.
+			args = append(args, []byte{1})
+			args = append(args, w.writeTypeRef(T.Origin()))
+			args = append(args, uvarintBytes(uint64(targs.Len())))
+			for i := 0; i < targs.Len(); i++ {
+				args = append(args, w.writeTypeRef(targs.At(i)))
+			}
+			return encodeBytes(args...)
+		}
+
+		args = append(args, []byte{0})
+		args = append(args, w.writeTypeRef(T.Underlying()))
+		args = append(args, w.writeObjRef(T.Obj()))
+		args = append(args, w.writeTypeParamRefs(T.TypeParams()))
+
+		n := uvarintBytes(uint64(T.NumMethods()))
+		args = append(args, n)
+		for i := 0; i < T.NumMethods(); i++ {
+			args = append(args, w.writeObjRef(T.Method(i)))
+		}
+		return encodeBytes(args...)
+	case *types.TypeParam:
+		return encodeBytes(
+			[]byte{kindTypeParam},
+			uvarintBytes(uint64(T.Index())),
+			w.writeTypeRef(T.Constraint()),
+			w.writeObjRef(T.Obj()),
+		)
+	case *types.Union:
+		var args [][]byte
+		args = append(args, []byte{kindUnion})
+		args = append(args, uvarintBytes(uint64(T.Len())))
+		for i := 0; i < T.Len(); i++ {
+			term := T.Term(i)
+			tilde := byte(0)
+			if term.Tilde() {
+				tilde = 1
+			}
+			args = append(args, []byte{kindTerm, tilde})
+			args = append(args, w.writeTypeRef(term.Type()))
+		}
+		return encodeBytes(args...)
+	case *types.Slice:
+		return encodeBytes([]byte{kindSlice}, w.writeTypeRef(T.Elem()))
+	case *types.Pointer:
+		return encodeBytes([]byte{kindPointer}, w.writeTypeRef(T.Elem()))
+	case *types.Interface:
+		var args [][]byte
+		args = append(args, []byte{kindInterface})
+
+		args = append(args, uvarintBytes(uint64(T.NumExplicitMethods())))
+		for i := 0; i < T.NumExplicitMethods(); i++ {
+			args = append(args, w.writeObjRef(T.ExplicitMethod(i)))
+		}
+
+		args = append(args, uvarintBytes(uint64(T.NumEmbeddeds())))
+		for i := 0; i < T.NumEmbeddeds(); i++ {
+			args = append(args, w.writeTypeRef(T.Embedded(i)))
+		}
+		return encodeBytes(args...)
+	case *types.Array:
+		return encodeBytes(
+			[]byte{kindArray},
+			w.writeTypeRef(T.Elem()),
+			uvarintBytes(uint64(T.Len())),
+		)
+	case *types.Struct:
+		var args [][]byte
+		args = append(args, []byte{kindStruct})
+		for i := 0; i < T.NumFields(); i++ {
+			field := T.Field(i)
+			tag := T.Tag(i)
+			args = append(args, w.writeObjRef(field))
+			args = append(args, []byte(tag))
+		}
+		return encodeBytes(args...)
+	case *types.Tuple:
+		var args [][]byte
+		args = append(args, []byte{kindTuple})
+		for i := 0; i < T.Len(); i++ {
+			args = append(args, w.writeObjRef(T.At(i)))
+		}
+		return encodeBytes(args...)
+	case *types.Map:
+		return encodeBytes(
+			[]byte{kindMap},
+			w.writeTypeRef(T.Key()),
+			w.writeTypeRef(T.Elem()),
+		)
+	case *types.Chan:
+		return encodeBytes(
+			[]byte{kindChan},
+			w.writeTypeRef(T.Elem()),
+			[]byte{byte(T.Dir())},
+		)
+	default:
+		panic(T)
+	}
+}
+
+// finish serializes the string table, type section, object section,
+// and the name -> object index into the final blob.
+func (w *exportWriter) finish() []byte {
+	var buf bytes.Buffer
+	buf.Write(uvarintBytes(exportVersion))
+
+	buf.Write(uvarintBytes(uint64(len(w.strList))))
+	for _, s := range w.strList {
+		buf.Write(uvarintBytes(uint64(len(s))))
+		buf.WriteString(s)
+	}
+
+	buf.Write(uvarintBytes(uint64(len(w.typBuf))))
+	var typOffsets []int
+	for _, entry := range w.typBuf {
+		typOffsets = append(typOffsets, buf.Len())
+		buf.Write(uvarintBytes(uint64(len(entry))))
+		buf.Write(entry)
+	}
+
+	buf.Write(uvarintBytes(uint64(len(w.objBuf))))
+	var objOffsets []int
+	for _, entry := range w.objBuf {
+		objOffsets = append(objOffsets, buf.Len())
+		buf.Write(uvarintBytes(uint64(len(entry))))
+		buf.Write(entry)
+	}
+
+	buf.Write(uvarintBytes(uint64(len(w.names))))
+	for _, name := range w.names {
+		obj := w.pkg.Scope().Lookup(name)
+		idx := w.objIdx[obj]
+		buf.Write(uvarintBytes(uint64(w.stringIndex(name))))
+		buf.Write(uvarintBytes(uint64(objOffsets[idx])))
+	}
+
+	return buf.Bytes()
+}
+
+func uvarintBytes(v uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(b, v)
+	return b[:n]
+}
+
+func encodeBytes(vs ...[]byte) []byte {
+	var out []byte
+	num := make([]byte, binary.MaxVarintLen64)
+	for _, v := range vs {
+		n := binary.PutUvarint(num, uint64(len(v)))
+		out = append(out, num[:n]...)
+		out = append(out, v...)
+	}
+	return out
+}
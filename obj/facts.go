@@ -0,0 +1,406 @@
+package obj
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"go/types"
+	"reflect"
+	"strconv"
+
+	"github.com/dgraph-io/badger"
+	"golang.org/x/tools/go/analysis"
+)
+
+var (
+	objectType = reflect.TypeOf((*types.Object)(nil)).Elem()
+	typeType   = reflect.TypeOf((*types.Type)(nil)).Elem()
+)
+
+// RegisterFact registers fact's concrete type with gob, under the
+// same analyzer-namespaced name EncodeFact/Facts use as part of the
+// badger key. It must be called (typically from an init function)
+// for every fact type an analyzer encodes or decodes, before
+// EncodeFact, EncodePackageFact, Facts or PackageFacts touch that
+// type, so facts from different analyzers never collide even though
+// they share one store.
+func RegisterFact(fact analysis.Fact) {
+	gob.RegisterName(factTypeName(fact), fact)
+}
+
+// factTypeName derives a stable name for fact's concrete type from
+// its package path, so two analyzers (or two versions of the same
+// one) that happen to pick the same short type name still get
+// distinct badger keys and distinct gob wire names.
+func factTypeName(fact analysis.Fact) string {
+	t := reflect.TypeOf(fact)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+// factObjRef and factTypeRef stand in for a types.Object or
+// types.Type field that's been stripped out of a fact before gob
+// encoding (gob can't encode the unexported concrete types behind
+// those interfaces), identifying the referenced value by the same
+// (package path, dense ID) pairs objectIndex/typeIndex assign during
+// export. A zero value (empty Path) marks a field that was nil to
+// begin with.
+type factObjRef struct {
+	Path string
+	ID   int
+}
+
+// factTypeRef is a (package path, dense ID) pair for an ordinary
+// type, or, when Basic is set, the name of a *types.Basic (int,
+// string, ...). Basic types are never passed to typeIndex/internTyp
+// --- encodeType's writeTypeRef special-cases them as an inline,
+// name-only reference, the same one Basic resolves here --- so a
+// fact field holding one (a very ordinary thing: types.Typ[types.Int]
+// is as "arbitrary" a types.Type as any) needs this separate path
+// instead of failing the typeID lookup every other type goes through.
+type factTypeRef struct {
+	Path  string
+	ID    int
+	Basic string
+}
+
+// factEnvelope is what's actually stored in badger: fact's own gob
+// encoding, with every types.Object/types.Type field zeroed out, plus
+// the refs needed to rebind them on the way back out, in the order
+// stripFactRefs encountered them.
+type factEnvelope struct {
+	Fact    []byte
+	ObjRefs []factObjRef
+	TypRefs []factTypeRef
+}
+
+// EncodeFact persists fact for obj, keyed by obj's package, its
+// dense object ID, and fact's registered type name, so that Facts can
+// later find every fact ever recorded for obj regardless of which
+// analyzer produced it.
+func (g *Graph) EncodeFact(obj types.Object, fact analysis.Fact) error {
+	path, id, err := g.objectID(obj)
+	if err != nil {
+		return err
+	}
+	return g.storeFact(path, strconv.Itoa(id), fact)
+}
+
+// EncodePackageFact persists fact for pkg as a whole, under the
+// fixed subject "pkg" rather than an object ID.
+func (g *Graph) EncodePackageFact(pkg *types.Package, fact analysis.Fact) error {
+	return g.storeFact(pkg.Path(), "pkg", fact)
+}
+
+func (g *Graph) storeFact(pkgPath, subject string, fact analysis.Fact) error {
+	stripped, objRefs, typRefs, err := g.stripFactRefs(fact)
+	if err != nil {
+		return err
+	}
+
+	var factBuf bytes.Buffer
+	if err := gob.NewEncoder(&factBuf).Encode(&stripped); err != nil {
+		return fmt.Errorf("obj: encoding fact %T: %v", fact, err)
+	}
+
+	var envBuf bytes.Buffer
+	env := factEnvelope{Fact: factBuf.Bytes(), ObjRefs: objRefs, TypRefs: typRefs}
+	if err := gob.NewEncoder(&envBuf).Encode(&env); err != nil {
+		return fmt.Errorf("obj: encoding fact envelope for %T: %v", fact, err)
+	}
+
+	key := []byte(fmt.Sprintf("facts/%s\x00%s\x00%s", pkgPath, subject, factTypeName(fact)))
+	return g.kv.Set(key, envBuf.Bytes(), 0)
+}
+
+// Facts returns every fact recorded for obj, in no particular order.
+func (g *Graph) Facts(obj types.Object) ([]analysis.Fact, error) {
+	path, id, err := g.objectID(obj)
+	if err != nil {
+		return nil, err
+	}
+	return g.loadFacts(path, strconv.Itoa(id))
+}
+
+// PackageFacts returns every fact recorded for pkg as a whole, in no
+// particular order.
+func (g *Graph) PackageFacts(pkg *types.Package) ([]analysis.Fact, error) {
+	return g.loadFacts(pkg.Path(), "pkg")
+}
+
+func (g *Graph) loadFacts(pkgPath, subject string) ([]analysis.Fact, error) {
+	prefix := []byte(fmt.Sprintf("facts/%s\x00%s\x00", pkgPath, subject))
+
+	it := g.kv.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	var facts []analysis.Fact
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		envBlob, err := kvItemValue(it.Item())
+		if err != nil {
+			return nil, fmt.Errorf("obj: reading fact envelope in %q: %v", pkgPath, err)
+		}
+		var env factEnvelope
+		if err := gob.NewDecoder(bytes.NewReader(envBlob)).Decode(&env); err != nil {
+			return nil, fmt.Errorf("obj: decoding fact envelope in %q: %v", pkgPath, err)
+		}
+
+		var fact analysis.Fact
+		if err := gob.NewDecoder(bytes.NewReader(env.Fact)).Decode(&fact); err != nil {
+			return nil, fmt.Errorf("obj: decoding fact in %q: %v", pkgPath, err)
+		}
+		if err := g.restoreFactRefs(fact, env.ObjRefs, env.TypRefs); err != nil {
+			return nil, err
+		}
+		facts = append(facts, fact)
+	}
+	return facts, nil
+}
+
+// stripFactRefs returns a copy of fact with every types.Object and
+// types.Type field (found by walking fact's structure with
+// reflection) replaced by its zero value, along with the refs needed
+// to restore them, in encounter order. fact must be a non-nil
+// pointer, as analysis.Fact values always are.
+func (g *Graph) stripFactRefs(fact analysis.Fact) (analysis.Fact, []factObjRef, []factTypeRef, error) {
+	v := reflect.ValueOf(fact)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, nil, nil, fmt.Errorf("obj: fact %T is not a non-nil pointer, can't encode its references", fact)
+	}
+
+	var objRefs []factObjRef
+	var typRefs []factTypeRef
+	elem, err := stripWalk(g, v.Elem(), &objRefs, &typRefs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	out := reflect.New(elem.Type())
+	out.Elem().Set(elem)
+	return out.Interface().(analysis.Fact), objRefs, typRefs, nil
+}
+
+// restoreFactRefs walks fact the same way stripFactRefs did,
+// consuming objRefs/typRefs in the same order they were produced and
+// rebinding each corresponding field in place.
+func (g *Graph) restoreFactRefs(fact analysis.Fact, objRefs []factObjRef, typRefs []factTypeRef) error {
+	v := reflect.ValueOf(fact)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("obj: fact %T is not a non-nil pointer, can't rebind its references", fact)
+	}
+	oi, ti := 0, 0
+	return restoreWalk(g, v.Elem(), objRefs, &oi, typRefs, &ti)
+}
+
+// stripWalk recurses into v, zeroing and recording any
+// types.Object/types.Type field it finds along the way. It only
+// descends into the composite kinds a fact struct plausibly uses
+// (pointer, interface, struct, slice, array, map); anything else is
+// copied through unchanged.
+func stripWalk(g *Graph, v reflect.Value, objRefs *[]factObjRef, typRefs *[]factTypeRef) (reflect.Value, error) {
+	if !v.IsValid() {
+		return v, nil
+	}
+
+	switch v.Type() {
+	case objectType:
+		if v.IsNil() {
+			*objRefs = append(*objRefs, factObjRef{})
+			return v, nil
+		}
+		path, id, err := g.objectID(v.Interface().(types.Object))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		*objRefs = append(*objRefs, factObjRef{Path: path, ID: id})
+		return reflect.Zero(v.Type()), nil
+	case typeType:
+		if v.IsNil() {
+			*typRefs = append(*typRefs, factTypeRef{})
+			return v, nil
+		}
+		if basic, ok := v.Interface().(*types.Basic); ok {
+			*typRefs = append(*typRefs, factTypeRef{Basic: basic.Name()})
+			return reflect.Zero(v.Type()), nil
+		}
+		path, id, err := g.typeID(v.Interface().(types.Type))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		*typRefs = append(*typRefs, factTypeRef{Path: path, ID: id})
+		return reflect.Zero(v.Type()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v, nil
+		}
+		elem, err := stripWalk(g, v.Elem(), objRefs, typRefs)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out := reflect.New(elem.Type())
+		out.Elem().Set(elem)
+		return out, nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, nil
+		}
+		elem, err := stripWalk(g, v.Elem(), objRefs, typRefs)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.Set(elem)
+		return out, nil
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			fv, err := stripWalk(g, v.Field(i), objRefs, typRefs)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			out.Field(i).Set(fv)
+		}
+		return out, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return v, nil
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			fv, err := stripWalk(g, v.Index(i), objRefs, typRefs)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			out.Index(i).Set(fv)
+		}
+		return out, nil
+	case reflect.Array:
+		out := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			fv, err := stripWalk(g, v.Index(i), objRefs, typRefs)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			out.Index(i).Set(fv)
+		}
+		return out, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return v, nil
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			fv, err := stripWalk(g, iter.Value(), objRefs, typRefs)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			out.SetMapIndex(iter.Key(), fv)
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// restoreWalk mirrors stripWalk's traversal over a freshly gob-decoded
+// fact, consuming one ref per Object/Type-typed field it visits (in
+// the same order stripWalk produced them) and setting the field when
+// the ref isn't the empty "was nil" placeholder.
+func restoreWalk(g *Graph, v reflect.Value, objRefs []factObjRef, objIdx *int, typRefs []factTypeRef, typIdx *int) error {
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch v.Type() {
+	case objectType:
+		ref := objRefs[*objIdx]
+		*objIdx++
+		if ref.Path == "" {
+			return nil
+		}
+		obj, ok := g.objectByID(ref.Path, ref.ID)
+		if !ok {
+			return fmt.Errorf("obj: fact refers to object %s/%d, which isn't in the graph", ref.Path, ref.ID)
+		}
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	case typeType:
+		ref := typRefs[*typIdx]
+		*typIdx++
+		if ref.Basic != "" {
+			b, ok := basicTypes[ref.Basic]
+			if !ok {
+				return fmt.Errorf("obj: fact refers to unknown builtin type %q", ref.Basic)
+			}
+			v.Set(reflect.ValueOf(b))
+			return nil
+		}
+		if ref.Path == "" {
+			return nil
+		}
+		typ, ok := g.typeByID(ref.Path, ref.ID)
+		if !ok {
+			return fmt.Errorf("obj: fact refers to type %s/%d, which isn't in the graph", ref.Path, ref.ID)
+		}
+		v.Set(reflect.ValueOf(typ))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return restoreWalk(g, v.Elem(), objRefs, objIdx, typRefs, typIdx)
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := restoreWalk(g, elem, objRefs, objIdx, typRefs, typIdx); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := restoreWalk(g, v.Field(i), objRefs, objIdx, typRefs, typIdx); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := restoreWalk(g, v.Index(i), objRefs, objIdx, typRefs, typIdx); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			val := reflect.New(iter.Value().Type()).Elem()
+			val.Set(iter.Value())
+			if err := restoreWalk(g, val, objRefs, objIdx, typRefs, typIdx); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), val)
+		}
+		return nil
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,157 @@
+package obj
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+)
+
+// fingerprintPackage hashes everything that determines whether a
+// previously compiled package is still up to date: its source (and
+// cgo) file contents, the build context it was compiled under, the
+// toolchain that compiled it, and --- Merkle-style --- the
+// fingerprints of its direct imports, so that changing a dependency
+// invalidates everything downstream of it without re-hashing the
+// dependency's own sources here.
+func fingerprintPackage(lp *LoadedPackage, buildCtx build.Context, importFPs map[string][]byte) ([]byte, error) {
+	h := sha256.New()
+
+	files := make([]string, 0, len(lp.GoFiles)+len(lp.CgoFiles))
+	files = append(files, lp.GoFiles...)
+	files = append(files, lp.CgoFiles...)
+	sort.Strings(files)
+
+	for _, f := range files {
+		full := filepath.Join(lp.Dir, f)
+		data, err := ioutil.ReadFile(full)
+		if err != nil {
+			// Fall back to mtime+size when the source can no longer
+			// be read (e.g. a build from a detached blob store);
+			// still gives us *a* signal that something changed.
+			fi, statErr := os.Stat(full)
+			if statErr != nil {
+				return nil, err
+			}
+			fmt.Fprintf(h, "stat\x00%s\x00%d\x00%d\x00", f, fi.Size(), fi.ModTime().UnixNano())
+			continue
+		}
+		fmt.Fprintf(h, "src\x00%s\x00%d\x00", f, len(data))
+		h.Write(data)
+	}
+
+	tags := append([]string{}, buildCtx.BuildTags...)
+	sort.Strings(tags)
+	fmt.Fprintf(h, "tags=%v\x00goos=%s\x00goarch=%s\x00module=%s\x00", tags, buildCtx.GOOS, buildCtx.GOARCH, lp.ModulePath)
+	fmt.Fprintf(h, "toolchain=%s\x00", runtime.Version())
+
+	imports := append([]string{}, lp.Imports...)
+	sort.Strings(imports)
+	for _, imp := range imports {
+		fmt.Fprintf(h, "import=%s\x00", imp)
+		h.Write(importFPs[imp])
+	}
+
+	return h.Sum(nil), nil
+}
+
+// computeFingerprint fingerprints lp, folding in the already
+// recorded fingerprints of its direct imports. An import that has no
+// recorded fingerprint yet (e.g. it hasn't been compiled in this
+// session) contributes nothing to the hash, which is safe: it can
+// only make a fingerprint mismatch more likely, never hide one.
+func (g *Graph) computeFingerprint(lp *LoadedPackage) ([]byte, error) {
+	importFPs := make(map[string][]byte, len(lp.Imports))
+	for _, imp := range lp.Imports {
+		if imp == "unsafe" {
+			continue
+		}
+		fp, err := g.Fingerprint(imp)
+		if err != nil {
+			continue
+		}
+		importFPs[imp] = fp
+	}
+	return fingerprintPackage(lp, g.build, importFPs)
+}
+
+// Fingerprint returns the fingerprint recorded for path the last
+// time it was inserted into the graph, so that callers (e.g.
+// staticcheck's own fact cache) can key their derived caches off the
+// same content-addressed value instead of maintaining a parallel
+// notion of "up to date".
+func (g *Graph) Fingerprint(path string) ([]byte, error) {
+	var item badger.KVItem
+	if err := g.kv.Get([]byte(fmt.Sprintf("pkgs/%s\x00fingerprint", path)), &item); err != nil {
+		return nil, err
+	}
+	v, err := kvItemValue(&item)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("obj: no fingerprint recorded for %q", path)
+	}
+	return v, nil
+}
+
+// invalidate drops every key belonging to path. In the indexed
+// export format a package owns exactly one export blob and one info
+// blob plus its name/fingerprint/imports bookkeeping (and any facts
+// recorded for it), so unlike the old UUID
+// scheme there are no orphaned types/* or objects/* keys to chase
+// down separately.
+//
+// It runs synchronously rather than in its own goroutine: HasPackage
+// calls it right before its caller (doImportFrom) recompiles path and
+// writes fresh entries back via InsertPackage, and path is held under
+// withPackageLatch for that whole span, so nothing else can be
+// racing to write path's keys. A backgrounded sweep would have no
+// such guarantee --- its iterator could just as easily run after the
+// fresh write lands as before it --- and would delete the very data
+// it's racing against.
+func (g *Graph) invalidate(path string) {
+	g.gcPackage(path)
+}
+
+func (g *Graph) gcPackage(path string) {
+	prefixes := [][]byte{
+		[]byte(fmt.Sprintf("pkgs/%s\x00", path)),
+		[]byte(fmt.Sprintf("facts/%s\x00", path)),
+	}
+
+	var keys [][]byte
+	it := g.kv.NewIterator(badger.DefaultIteratorOptions)
+	for _, prefix := range prefixes {
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			k := it.Item().Key()
+			kc := make([]byte, len(k))
+			copy(kc, k)
+			keys = append(keys, kc)
+		}
+	}
+	it.Close()
+
+	for _, k := range keys {
+		if err := g.kv.Delete(k); err != nil {
+			log.Println("obj: gc:", path, err)
+		}
+	}
+
+	g.mu.Lock()
+	delete(g.pkgExports, path)
+	// A stale object/type's dense IDs may no longer correspond to
+	// what's stored on disk, so any facts referencing them would be
+	// rebound to the wrong value; drop the tables along with
+	// everything else so a later reload starts clean.
+	delete(g.objTables, path)
+	delete(g.typTables, path)
+	g.mu.Unlock()
+}
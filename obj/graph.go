@@ -1,39 +1,24 @@
 package obj
 
 import (
-	"encoding/binary"
+	"bytes"
 	"fmt"
 	"go/ast"
 	"go/build"
-	"go/parser"
 	"go/token"
 	"go/types"
 	"log"
-	"reflect"
-
-	"golang.org/x/tools/go/buildutil"
-	"honnef.co/go/tools/obj/cgo"
+	"sync"
 
 	"github.com/dgraph-io/badger"
-	uuid "github.com/satori/go.uuid"
 )
 
-// OPT(dh): in types with elems like slices, consider storing the
-// concrete underlying type together with the type ID, so that we can
-// defer the actual lookup
-
-// OPT(dh): also consider not using UUIDs for types. if the IDs were
-// sequential, we could use a range query to load all referred types
-// in one go. UUIDs do help with multiple tools writing to the same
-// database, though.
-
 // OPT(dh): optimize calculation of IDs (use byte slices and in-place
 // modifications instead of all the Sprintf calls)
 
 // OPT(dh): use batch sets when inserting data
 
 // TODO(dh): add index mapping package names to import pathscd
-// TODO(dh): store AST, types.Info and checksums
 
 type Graph struct {
 	curpkg string
@@ -42,25 +27,62 @@ type Graph struct {
 
 	kv *badger.KV
 
-	objToID map[types.Object][]byte
-	typToID map[types.Type][]byte
+	// mu guards every map below, all of which are read and written
+	// from ImportFrom and its helpers. ImportAll compiles independent
+	// packages concurrently, so these maps --- unlike badger itself,
+	// which is already safe for concurrent use --- need their own
+	// lock.
+	mu sync.Mutex
+
+	// inflight holds one latch per import path currently being
+	// compiled or loaded, so that concurrent ImportFrom calls for the
+	// same path (e.g. two packages that share a dependency, compiled
+	// by two different workers) coalesce onto a single piece of work
+	// instead of racing to insert it twice.
+	inflight map[string]*importLatch
+
+	// pkgExports caches the decoded index of a package's export blob,
+	// keyed by import path, so repeated lookups don't re-parse the
+	// string table and object index.
+	pkgExports map[string]*pkgIndex
 
-	idToObj map[string]types.Object
-	idToTyp map[string]types.Type
 	idToPkg map[string]*types.Package
 
 	// OPT(dh): merge idToPkg and pkgs
 	pkgs map[string]*types.Package
 
-	scopes map[*types.Package]map[string][]byte
-	set    []*badger.Entry
-
-	build build.Context
+	// readers caches the importReader used to load each package from
+	// the graph, keyed by import path, so PackageInfo can resolve the
+	// object/type references in a later-requested info blob through
+	// the same dense indices loadPackage already decoded.
+	readers map[string]*importReader
+
+	// liveInfo and livePackages hold the *types.Info and parsed files
+	// (wrapped as an *ast.Package) for packages compiled by this
+	// process this session, so PackageInfo can hand them back
+	// directly instead of round-tripping through the info blob it
+	// also persists for them.
+	liveInfo     map[string]*types.Info
+	livePackages map[string]*ast.Package
+
+	// objTables and typTables give the objects and types of each
+	// package stable, dense IDs for use by the facts subsystem, keyed
+	// by import path. They're populated incrementally as objects and
+	// types pass through objectIndex/typeIndex (encoding) or
+	// readObjectAt/readTypeAt (decoding).
+	objTables map[string]*objTable
+	typTables map[string]*typTable
+
+	build  build.Context
+	loader PackageLoader
 
 	checker *types.Config
 }
 
-func OpenGraph(dir string) (*Graph, error) {
+// OpenGraph opens (creating, if necessary) the graph stored at dir.
+// opts may be nil to use the process's own environment and working
+// directory.
+func OpenGraph(dir string, opts *Options) (*Graph, error) {
 	opt := badger.DefaultOptions
 	opt.Dir = dir
 	opt.ValueDir = dir
@@ -70,18 +92,21 @@ func OpenGraph(dir string) (*Graph, error) {
 	}
 
 	g := &Graph{
-		Fset:    token.NewFileSet(),
-		kv:      kv,
-		objToID: map[types.Object][]byte{},
-		typToID: map[types.Type][]byte{},
-		idToObj: map[string]types.Object{},
-		idToTyp: map[string]types.Type{},
-		idToPkg: map[string]*types.Package{},
-		pkgs:    map[string]*types.Package{},
-		scopes:  map[*types.Package]map[string][]byte{},
-		build:   build.Default,
-		checker: &types.Config{},
+		Fset:         token.NewFileSet(),
+		kv:           kv,
+		inflight:     map[string]*importLatch{},
+		pkgExports:   map[string]*pkgIndex{},
+		idToPkg:      map[string]*types.Package{},
+		pkgs:         map[string]*types.Package{},
+		readers:      map[string]*importReader{},
+		liveInfo:     map[string]*types.Info{},
+		livePackages: map[string]*ast.Package{},
+		objTables:    map[string]*objTable{},
+		typTables:    map[string]*typTable{},
+		build:        build.Default,
+		checker:      &types.Config{},
 	}
+	g.loader = newDefaultLoader(g.build, opts)
 	g.checker.Importer = g
 
 	return g, nil
@@ -91,49 +116,91 @@ func (g *Graph) Import(path string) (*types.Package, error) {
 	panic("not implemented, use ImportFrom")
 }
 
+// importLatch is the in-flight marker ImportFrom leaves for a path
+// it's currently compiling or loading, so that a second caller asking
+// for the same path blocks on this one's result instead of starting
+// redundant (and, for two concurrent writers, corrupting) work.
+type importLatch struct {
+	done chan struct{}
+	pkg  *types.Package
+	err  error
+}
+
+// withPackageLatch returns path's package, coalescing concurrent
+// callers behind a single build() call: the first caller for path
+// runs build and publishes its result to every other caller --
+// whether they arrived via ImportFrom or, for a dependency pulled in
+// while decoding another package's blob, via foreignPackage -- instead
+// of each racing to compile or load it themselves.
+func (g *Graph) withPackageLatch(path string, build func() (*types.Package, error)) (*types.Package, error) {
+	g.mu.Lock()
+	if pkg, ok := g.pkgs[path]; ok {
+		g.mu.Unlock()
+		return pkg, nil
+	}
+	if latch, ok := g.inflight[path]; ok {
+		g.mu.Unlock()
+		<-latch.done
+		return latch.pkg, latch.err
+	}
+	latch := &importLatch{done: make(chan struct{})}
+	g.inflight[path] = latch
+	g.mu.Unlock()
+
+	pkg, err := build()
+
+	latch.pkg, latch.err = pkg, err
+	close(latch.done)
+	g.mu.Lock()
+	delete(g.inflight, path)
+	g.mu.Unlock()
+
+	return pkg, err
+}
+
 func (g *Graph) ImportFrom(path, srcDir string, mode types.ImportMode) (*types.Package, error) {
-	bpkg, err := g.build.Import(path, srcDir, 0)
+	lp, err := g.loader.Load(g.Fset, path, srcDir)
 	if err != nil {
 		return nil, err
 	}
 
-	if bpkg.ImportPath == "unsafe" {
+	if lp.ImportPath == "unsafe" {
 		return types.Unsafe, nil
 	}
 
-	// TODO(dh): use checksum to verify that package is up to date
-	if pkg, ok := g.pkgs[bpkg.ImportPath]; ok {
-		return pkg, nil
-	}
-	if g.HasPackage(bpkg.ImportPath) {
-		log.Println("importing from graph:", bpkg.ImportPath)
-		pkg := g.Package(bpkg.ImportPath)
-		return pkg, nil
-	}
-
-	log.Println("compiling:", bpkg.ImportPath)
+	return g.withPackageLatch(lp.ImportPath, func() (*types.Package, error) {
+		return g.doImportFrom(path, lp)
+	})
+}
 
-	// TODO(dh): support returning partially built packages. For
-	// example, an invalid AST still is usable for some operations.
-	var files []*ast.File
-	for _, f := range bpkg.GoFiles {
-		af, err := buildutil.ParseFile(g.Fset, &g.build, nil, bpkg.Dir, f, parser.ParseComments)
+// doImportFrom does the actual work of ImportFrom --- checking
+// whether lp is already cached and, if not, compiling it --- once
+// withPackageLatch has established that this goroutine is the one
+// responsible for path.
+func (g *Graph) doImportFrom(path string, lp *LoadedPackage) (*types.Package, error) {
+	if g.HasPackage(lp) {
+		log.Println("importing from graph:", lp.ImportPath)
+		pkg, err := g.loadPackage(lp.ImportPath)
 		if err != nil {
 			return nil, err
 		}
-		files = append(files, af)
+		return pkg, nil
 	}
 
-	if len(bpkg.CgoFiles) > 0 {
-		cgoFiles, err := cgo.ProcessCgoFiles(bpkg, g.Fset, nil, parser.ParseComments)
-		if err != nil {
-			return nil, err
-		}
-		files = append(files, cgoFiles...)
-	}
+	log.Println("compiling:", lp.ImportPath)
 
-	// TODO(dh): collect info
-	info := &types.Info{}
+	// TODO(dh): support returning partially built packages. For
+	// example, an invalid AST still is usable for some operations.
+	files := lp.Files
+
+	info := &types.Info{
+		Types:      map[ast.Expr]types.TypeAndValue{},
+		Defs:       map[*ast.Ident]types.Object{},
+		Uses:       map[*ast.Ident]types.Object{},
+		Implicits:  map[ast.Node]types.Object{},
+		Selections: map[*ast.SelectorExpr]*types.Selection{},
+		Scopes:     map[ast.Node]*types.Scope{},
+	}
 	pkg, err := g.checker.Check(path, g.Fset, files, info)
 	if err != nil {
 		return nil, err
@@ -141,328 +208,183 @@ func (g *Graph) ImportFrom(path, srcDir string, mode types.ImportMode) (*types.P
 
 	// TODO(dh): build SSA
 
-	g.InsertPackage(bpkg, pkg)
+	pkgFiles := make(map[string]*ast.File, len(files))
+	for _, f := range files {
+		pkgFiles[g.Fset.Position(f.Pos()).Filename] = f
+	}
+	g.mu.Lock()
+	g.liveInfo[lp.ImportPath] = info
+	g.livePackages[lp.ImportPath] = &ast.Package{Name: pkg.Name(), Files: pkgFiles}
+	g.mu.Unlock()
+
+	if err := g.InsertPackage(lp, pkg, files, info); err != nil {
+		return nil, err
+	}
 	return pkg, nil
 }
 
-func (g *Graph) HasPackage(path string) bool {
+// HasPackage reports whether the graph holds an up-to-date compiled
+// form of lp. A package whose export blob is present but whose
+// recomputed fingerprint no longer matches the recorded one is
+// treated as absent, and its stale entries are garbage-collected
+// synchronously before HasPackage returns, so the caller's
+// subsequent recompile-and-reinsert can't race a background sweep
+// that's still deleting the same path's keys.
+func (g *Graph) HasPackage(lp *LoadedPackage) bool {
+	path := lp.ImportPath
 	if path == "unsafe" {
 		return true
 	}
-	if _, ok := g.pkgs[path]; ok {
+	g.mu.Lock()
+	_, cached := g.pkgs[path]
+	g.mu.Unlock()
+	if cached {
 		return true
 	}
-	ok, _ := g.kv.Exists([]byte(fmt.Sprintf("pkgs/%s\x00name", path)))
-	return ok
+	ok, _ := g.kv.Exists([]byte(fmt.Sprintf("pkgs/%s\x00export", path)))
+	if !ok {
+		return false
+	}
+
+	stored, err := g.Fingerprint(path)
+	if err != nil {
+		return false
+	}
+	fresh, err := g.computeFingerprint(lp)
+	if err != nil {
+		// Can't recompute (e.g. sources vanished); trust what's on
+		// disk rather than discard a perfectly good cache entry.
+		return true
+	}
+	if !bytes.Equal(stored, fresh) {
+		g.invalidate(path)
+		return false
+	}
+	return true
 }
 
-func (g *Graph) InsertPackage(bpkg *build.Package, pkg *types.Package) {
+// InsertPackage encodes pkg, along with info and the files it was
+// checked from, as a pair of sibling blobs and writes them under
+// pkgs/<path>\x00export and pkgs/<path>\x00info. The export blob is
+// self-contained: a string table, a name -> object index, and
+// type-data and object sections addressed by dense integer indices,
+// so a single object can be looked up by name and decoded from its
+// own entry (importReader.readObjectByName) instead of fanning out
+// across one KV entry per type or object. loadPackage, which a later
+// ImportFrom uses, still decodes every package-level object when it
+// opens the package. The info blob reuses the same writer's
+// string/type/object tables, so its own object and type references
+// resolve through the very same dense indices.
+func (g *Graph) InsertPackage(lp *LoadedPackage, pkg *types.Package, files []*ast.File, info *types.Info) error {
 	if pkg == types.Unsafe {
-		return
+		return nil
 	}
-	if _, ok := g.pkgs[bpkg.ImportPath]; ok {
-		return
+	g.mu.Lock()
+	if _, ok := g.pkgs[lp.ImportPath]; ok {
+		g.mu.Unlock()
+		return nil
 	}
+	g.pkgs[lp.ImportPath] = pkg
+	g.mu.Unlock()
 	log.Println("inserting", pkg)
-	g.pkgs[bpkg.ImportPath] = pkg
 
-	g.set = []*badger.Entry{}
+	w := newPackageWriter(g, pkg)
+	infoBlob, err := w.encodeInfo(g.Fset, files, info)
+	if err != nil {
+		return err
+	}
+	blob := w.finish()
+
+	fp, err := g.computeFingerprint(lp)
+	if err != nil {
+		return err
+	}
+
+	var set []*badger.Entry
 	for _, imp := range pkg.Imports() {
 		key := []byte(fmt.Sprintf("pkgs/%s\x00imports/%s", pkg.Path(), imp.Path()))
-		g.set = badger.EntriesSet(g.set, key, nil)
+		set = badger.EntriesSet(set, key, nil)
 	}
-
 	key := []byte(fmt.Sprintf("pkgs/%s\x00name", pkg.Path()))
-	g.set = badger.EntriesSet(g.set, key, []byte(pkg.Name()))
-
-	id := []byte(fmt.Sprintf("pkgs/%s\x00scopes/%s", pkg.Path(), g.encodeScope(pkg, pkg.Scope())))
-	key = []byte(fmt.Sprintf("pkgs/%s\x00scope", pkg.Path()))
-	g.set = badger.EntriesSet(g.set, key, id)
-
-	g.kv.BatchSet(g.set)
-	g.set = nil
+	set = badger.EntriesSet(set, key, []byte(pkg.Name()))
+	key = []byte(fmt.Sprintf("pkgs/%s\x00export", pkg.Path()))
+	set = badger.EntriesSet(set, key, blob)
+	key = []byte(fmt.Sprintf("pkgs/%s\x00fingerprint", pkg.Path()))
+	set = badger.EntriesSet(set, key, fp)
+	key = []byte(fmt.Sprintf("pkgs/%s\x00info", pkg.Path()))
+	set = badger.EntriesSet(set, key, infoBlob)
+
+	// BatchSetAsync rather than BatchSet: ImportAll flushes several
+	// packages' entries concurrently, and waiting for each one's
+	// fsync in turn would serialize workers that otherwise have no
+	// reason to wait on each other.
+	done := make(chan error, 1)
+	g.kv.BatchSetAsync(set, func(err error) { done <- err })
+	return <-done
 }
 
-func (g *Graph) encodeScope(pkg *types.Package, scope *types.Scope) [16]byte {
-	id := [16]byte(uuid.NewV1())
-
-	var args [][]byte
-
-	names := scope.Names()
-	n := make([]byte, binary.MaxVarintLen64)
-	l := binary.PutUvarint(n, uint64(len(names)))
-	n = n[:l]
-	args = append(args, n)
-
-	for _, name := range names {
-		obj := scope.Lookup(name)
-		g.encodeObject(obj)
-		args = append(args, g.objToID[obj])
-	}
-
-	n = make([]byte, binary.MaxVarintLen64)
-	l = binary.PutUvarint(n, uint64(scope.NumChildren()))
-	n = n[:l]
-	args = append(args, n)
-
-	for i := 0; i < scope.NumChildren(); i++ {
-		sid := g.encodeScope(pkg, scope.Child(i))
-		args = append(args, []byte(fmt.Sprintf("pkgs/%s\x00scopes/%s", pkg.Path(), sid)))
+// kvItemValue copies v's value out from under badger's iterator, via
+// the callback Value takes instead of returning the bytes directly,
+// so every caller gets a []byte it can keep past the KVItem's own
+// lifetime.
+func kvItemValue(item *badger.KVItem) ([]byte, error) {
+	var v []byte
+	if err := item.Value(func(val []byte) error {
+		v = append([]byte(nil), val...)
+		return nil
+	}); err != nil {
+		return nil, err
 	}
-
-	v := encodeBytes(args...)
-	key := []byte(fmt.Sprintf("pkgs/%s\x00scopes/%s", pkg.Path(), id))
-	g.set = badger.EntriesSet(g.set, key, v)
-
-	return id
+	return v, nil
 }
 
-const (
-	kindFunc = iota
-	kindVar
-	kindTypename
-	kindConst
-	kindPkgname
-
-	kindSignature
-	kindNamed
-	kindSlice
-	kindPointer
-	kindInterface
-	kindArray
-	kindStruct
-	kindTuple
-	kindMap
-	kindChan
-)
-
-func (g *Graph) encodeObject(obj types.Object) {
-	if _, ok := g.objToID[obj]; ok {
-		return
-	}
-	if obj.Pkg() == nil {
-		g.objToID[obj] = []byte(fmt.Sprintf("builtin/%s", obj.Name()))
-		return
-	}
-	id := uuid.NewV1()
-	path := obj.Pkg().Path()
-	key := []byte(fmt.Sprintf("pkgs/%s\x00objects/%s", path, [16]byte(id)))
-	g.objToID[obj] = key
-
-	g.encodeType(obj.Type())
-	typID := g.typToID[obj.Type()]
-	var typ byte
-	switch obj.(type) {
-	case *types.Func:
-		typ = kindFunc
-	case *types.Var:
-		typ = kindVar
-	case *types.TypeName:
-		typ = kindTypename
-	case *types.Const:
-		typ = kindConst
-	case *types.PkgName:
-		typ = kindPkgname
-	default:
-		panic(fmt.Sprintf("%T", obj))
+// loadPackage reads the export blob for path, decodes its index, and
+// materializes every package-level object it exposes, along with the
+// types those objects reference. Entries reachable only from
+// elsewhere (e.g. local objects referenced by the info blob) are
+// decoded only when something asks for them.
+func (g *Graph) loadPackage(path string) (*types.Package, error) {
+	var item badger.KVItem
+	key := []byte(fmt.Sprintf("pkgs/%s\x00export", path))
+	if err := g.kv.Get(key, &item); err != nil {
+		return nil, err
 	}
-
-	var v []byte
-	switch obj := obj.(type) {
-	case *types.PkgName:
-		v = encodeBytes(
-			[]byte(obj.Name()),
-			[]byte{typ},
-			typID,
-			[]byte(obj.Imported().Path()),
-		)
-	case *types.Const:
-		kind, data := encodeConstant(reflect.ValueOf(obj.Val()))
-		v = encodeBytes(
-			[]byte(obj.Name()),
-			[]byte{typ},
-			typID,
-			[]byte{kind},
-			data,
-		)
-	default:
-		v = encodeBytes(
-			[]byte(obj.Name()),
-			[]byte{typ},
-			typID,
-		)
+	blob, err := kvItemValue(&item)
+	if err != nil {
+		return nil, err
 	}
 
-	g.set = badger.EntriesSet(g.set, key, v)
-}
-
-func encodeBytes(vs ...[]byte) []byte {
-	var out []byte
-	num := make([]byte, binary.MaxVarintLen64)
-	for _, v := range vs {
-		n := binary.PutUvarint(num, uint64(len(v)))
-		out = append(out, num[:n]...)
-		out = append(out, v...)
+	idx, err := newPkgIndex(blob)
+	if err != nil {
+		return nil, fmt.Errorf("obj: corrupt export data for %s: %v", path, err)
 	}
-	return out
-}
 
-func (g *Graph) encodeType(T types.Type) {
-	if id := g.typToID[T]; id != nil {
-		return
+	var nameItem badger.KVItem
+	if err := g.kv.Get([]byte(fmt.Sprintf("pkgs/%s\x00name", path)), &nameItem); err != nil {
+		return nil, err
 	}
-	if T, ok := T.(*types.Basic); ok {
-		// OPT(dh): use an enum instead of strings for the built in
-		// types
-		g.typToID[T] = []byte(fmt.Sprintf("builtin/%s", T.Name()))
-		return
+	nameBlob, err := kvItemValue(&nameItem)
+	if err != nil {
+		return nil, err
 	}
-	id := uuid.NewV1()
-	key := []byte(fmt.Sprintf("types/%s", [16]byte(id)))
-	g.typToID[T] = key
-
-	switch T := T.(type) {
-	case *types.Signature:
-		g.encodeType(T.Params())
-		g.encodeType(T.Results())
-		if T.Recv() != nil {
-			g.encodeObject(T.Recv())
-		}
-
-		variadic := byte(0)
-		if T.Variadic() {
-			variadic = 1
-		}
-		params := g.typToID[T.Params()]
-		results := g.typToID[T.Results()]
-		recv := g.objToID[T.Recv()]
-
-		v := encodeBytes(
-			[]byte{kindSignature},
-			params,
-			results,
-			recv,
-			[]byte{variadic},
-		)
-
-		g.set = badger.EntriesSet(g.set, key, v)
-	case *types.Named:
-		var args [][]byte
-		args = append(args, []byte{kindNamed})
-
-		underlying := T.Underlying()
-		g.encodeType(underlying)
-		args = append(args, g.typToID[underlying])
-
-		typename := T.Obj()
-		g.encodeObject(typename)
-		args = append(args, g.objToID[typename])
-
-		for i := 0; i < T.NumMethods(); i++ {
-			fn := T.Method(i)
-			g.encodeObject(fn)
-			args = append(args, g.objToID[fn])
-		}
-		v := encodeBytes(args...)
-		g.set = badger.EntriesSet(g.set, key, v)
-	case *types.Slice:
-		elem := T.Elem()
-		g.encodeType(elem)
-		v := encodeBytes(
-			[]byte{kindSlice},
-			g.typToID[elem],
-		)
-		g.set = badger.EntriesSet(g.set, key, v)
-	case *types.Pointer:
-		elem := T.Elem()
-		g.encodeType(elem)
-		v := encodeBytes(
-			[]byte{kindPointer},
-			g.typToID[elem],
-		)
-		g.set = badger.EntriesSet(g.set, key, v)
-	case *types.Interface:
-		var args [][]byte
-		args = append(args, []byte{kindInterface})
-
-		n := make([]byte, binary.MaxVarintLen64)
-		l := binary.PutUvarint(n, uint64(T.NumExplicitMethods()))
-		args = append(args, n[:l])
-
-		for i := 0; i < T.NumExplicitMethods(); i++ {
-			fn := T.ExplicitMethod(i)
-			g.encodeObject(fn)
-			args = append(args, g.objToID[fn])
-		}
-
-		n = make([]byte, binary.MaxVarintLen64)
-		l = binary.PutUvarint(n, uint64(T.NumEmbeddeds()))
-		args = append(args, n[:l])
-
-		for i := 0; i < T.NumEmbeddeds(); i++ {
-			embedded := T.Embedded(i)
-			g.encodeType(embedded)
-			args = append(args, g.typToID[embedded])
-		}
-		v := encodeBytes(args...)
-		g.set = badger.EntriesSet(g.set, key, v)
-	case *types.Array:
-		elem := T.Elem()
-		g.encodeType(elem)
-
-		n := make([]byte, binary.MaxVarintLen64)
-		l := binary.PutUvarint(n, uint64(T.Len()))
-		n = n[:l]
-		v := encodeBytes(
-			[]byte{kindArray},
-			g.typToID[elem],
-			n,
-		)
-		g.set = badger.EntriesSet(g.set, key, v)
-	case *types.Struct:
-		var args [][]byte
-		args = append(args, []byte{kindStruct})
-		for i := 0; i < T.NumFields(); i++ {
-			field := T.Field(i)
-			tag := T.Tag(i)
-			g.encodeObject(field)
-
-			args = append(args, g.objToID[field])
-			args = append(args, []byte(tag))
-		}
-		v := encodeBytes(args...)
-		g.set = badger.EntriesSet(g.set, key, v)
-	case *types.Tuple:
-		var args [][]byte
-		args = append(args, []byte{kindTuple})
-		for i := 0; i < T.Len(); i++ {
-			v := T.At(i)
-			g.encodeObject(v)
-			args = append(args, g.objToID[v])
+	pkg := types.NewPackage(path, string(nameBlob))
+
+	g.mu.Lock()
+	g.pkgExports[path] = idx
+	g.pkgs[path] = pkg
+	g.idToPkg[path] = pkg
+	r := newImportReader(g, pkg, idx)
+	g.readers[path] = r
+	g.mu.Unlock()
+
+	for _, name := range idx.exportedNames() {
+		if _, err := r.readObjectByName(name); err != nil {
+			return nil, err
 		}
-		v := encodeBytes(args...)
-		g.set = badger.EntriesSet(g.set, key, v)
-	case *types.Map:
-		g.encodeType(T.Key())
-		g.encodeType(T.Elem())
-		v := encodeBytes(
-			[]byte{kindMap},
-			g.typToID[T.Key()],
-			g.typToID[T.Elem()],
-		)
-		g.set = badger.EntriesSet(g.set, key, v)
-	case *types.Chan:
-		g.encodeType(T.Elem())
-
-		v := encodeBytes(
-			[]byte{kindChan},
-			g.typToID[T.Elem()],
-			[]byte{byte(T.Dir())},
-		)
-		g.set = badger.EntriesSet(g.set, key, v)
-	default:
-		panic(fmt.Sprintf("%T", T))
 	}
+	pkg.MarkComplete()
+
+	return pkg, nil
 }
 
 func (g *Graph) Close() error {
@@ -0,0 +1,130 @@
+package obj
+
+import (
+	"fmt"
+	"go/types"
+)
+
+// objTable and typTable give the objects and types belonging to one
+// package stable, dense integer IDs --- the same indices the export
+// format already assigns them --- so that other subsystems (facts, in
+// particular) can refer to a types.Object or types.Type by a
+// (package path, ID) pair that survives a round trip through badger,
+// instead of holding on to the Go value itself.
+type objTable struct {
+	toID  map[types.Object]int
+	toObj map[int]types.Object
+}
+
+type typTable struct {
+	toID  map[types.Type]int
+	toTyp map[int]types.Type
+}
+
+// internObj records that obj is object id within path's package,
+// first-write-wins so that re-deriving the same object (e.g. once
+// while encoding, once while decoding in the same process) doesn't
+// clobber an existing association.
+func (g *Graph) internObj(path string, id int, obj types.Object) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t := g.objTables[path]
+	if t == nil {
+		t = &objTable{toID: map[types.Object]int{}, toObj: map[int]types.Object{}}
+		g.objTables[path] = t
+	}
+	if _, ok := t.toID[obj]; !ok {
+		t.toID[obj] = id
+	}
+	if _, ok := t.toObj[id]; !ok {
+		t.toObj[id] = obj
+	}
+}
+
+// objectID returns the package path and dense ID obj was interned
+// under. It only succeeds for objects that have already passed
+// through objectIndex (encoding) or readObjectAt (decoding) --- i.e.
+// objects belonging to a package that has been inserted into or
+// loaded from the graph.
+func (g *Graph) objectID(obj types.Object) (string, int, error) {
+	pkg := obj.Pkg()
+	if pkg == nil {
+		return "", 0, fmt.Errorf("obj: %v has no package and can't be used with facts", obj)
+	}
+	path := pkg.Path()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t := g.objTables[path]
+	if t == nil {
+		return "", 0, fmt.Errorf("obj: package %q hasn't been inserted into or loaded from the graph yet", path)
+	}
+	id, ok := t.toID[obj]
+	if !ok {
+		return "", 0, fmt.Errorf("obj: %v is not part of package %q's persisted export data", obj, path)
+	}
+	return path, id, nil
+}
+
+func (g *Graph) objectByID(path string, id int) (types.Object, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t := g.objTables[path]
+	if t == nil {
+		return nil, false
+	}
+	obj, ok := t.toObj[id]
+	return obj, ok
+}
+
+func (g *Graph) internTyp(path string, id int, typ types.Type) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t := g.typTables[path]
+	if t == nil {
+		t = &typTable{toID: map[types.Type]int{}, toTyp: map[int]types.Type{}}
+		g.typTables[path] = t
+	}
+	if _, ok := t.toID[typ]; !ok {
+		t.toID[typ] = id
+	}
+	if _, ok := t.toTyp[id]; !ok {
+		t.toTyp[id] = typ
+	}
+}
+
+// typeID returns the package path and dense ID typ was interned
+// under. Unlike objects, not every type is guaranteed one: only the
+// kinds readTypeAt already memoizes (Named, TypeParam, Slice,
+// Pointer) are guaranteed an ID after a fresh load; the rest only
+// have one if this same process also encoded them this session.
+func (g *Graph) typeID(typ types.Type) (string, int, error) {
+	named, ok := typ.(*types.Named)
+	path := ""
+	if ok && named.Obj().Pkg() != nil {
+		path = named.Obj().Pkg().Path()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for p, t := range g.typTables {
+		if path != "" && p != path {
+			continue
+		}
+		if id, ok := t.toID[typ]; ok {
+			return p, id, nil
+		}
+	}
+	return "", 0, fmt.Errorf("obj: %v was never interned by this graph and can't be used with facts", typ)
+}
+
+func (g *Graph) typeByID(path string, id int) (types.Type, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t := g.typTables[path]
+	if t == nil {
+		return nil, false
+	}
+	typ, ok := t.toTyp[id]
+	return typ, ok
+}
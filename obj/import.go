@@ -0,0 +1,617 @@
+package obj
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go/token"
+	"go/types"
+)
+
+// This file implements the reader half of the graph's indexed export
+// format. newPkgIndex parses the cheap, fixed-overhead header of a
+// package blob (string table plus the type/object offset tables and
+// the name index) without decoding a single object. importReader then
+// materializes objects on demand: looking up one name touches only
+// the bytes of that object's entry and whatever it transitively
+// references, not the whole package.
+
+var basicTypes = func() map[string]*types.Basic {
+	m := make(map[string]*types.Basic, len(types.Typ))
+	for _, t := range types.Typ {
+		m[t.Name()] = t
+	}
+	return m
+}()
+
+// pkgIndex is the decoded header of a package's export blob: enough
+// to look up any type or object by dense index, or any top-level
+// name by its recorded offset, without re-scanning the blob.
+type pkgIndex struct {
+	blob []byte
+
+	strs []string
+
+	typeOffsets []int
+	objOffsets  []int
+
+	names         []string
+	nameToOffset  map[string]int
+	offsetToIndex map[int]int
+}
+
+func newPkgIndex(blob []byte) (*pkgIndex, error) {
+	pos := 0
+	version, n := binary.Uvarint(blob[pos:])
+	pos += n
+	if version != exportVersion {
+		return nil, fmt.Errorf("unsupported export data version %d", version)
+	}
+
+	numStrs, n := binary.Uvarint(blob[pos:])
+	pos += n
+	strs := make([]string, numStrs)
+	for i := range strs {
+		l, n := binary.Uvarint(blob[pos:])
+		pos += n
+		strs[i] = string(blob[pos : pos+int(l)])
+		pos += int(l)
+	}
+
+	numTypes, n := binary.Uvarint(blob[pos:])
+	pos += n
+	typeOffsets := make([]int, numTypes)
+	for i := range typeOffsets {
+		typeOffsets[i] = pos
+		l, n := binary.Uvarint(blob[pos:])
+		pos += n
+		pos += int(l)
+	}
+
+	numObjs, n := binary.Uvarint(blob[pos:])
+	pos += n
+	objOffsets := make([]int, numObjs)
+	offsetToIndex := make(map[int]int, numObjs)
+	for i := range objOffsets {
+		objOffsets[i] = pos
+		offsetToIndex[pos] = i
+		l, n := binary.Uvarint(blob[pos:])
+		pos += n
+		pos += int(l)
+	}
+
+	numNames, n := binary.Uvarint(blob[pos:])
+	pos += n
+	names := make([]string, 0, numNames)
+	nameToOffset := make(map[string]int, numNames)
+	for i := 0; i < int(numNames); i++ {
+		nameID, n := binary.Uvarint(blob[pos:])
+		pos += n
+		off, n := binary.Uvarint(blob[pos:])
+		pos += n
+		name := strs[nameID]
+		names = append(names, name)
+		nameToOffset[name] = int(off)
+	}
+
+	return &pkgIndex{
+		blob:          blob,
+		strs:          strs,
+		typeOffsets:   typeOffsets,
+		objOffsets:    objOffsets,
+		names:         names,
+		nameToOffset:  nameToOffset,
+		offsetToIndex: offsetToIndex,
+	}, nil
+}
+
+func (idx *pkgIndex) exportedNames() []string {
+	return idx.names
+}
+
+// readLengthPrefixed returns the uvarint-length-prefixed payload
+// starting at off.
+func (idx *pkgIndex) readLengthPrefixed(off int) []byte {
+	l, n := binary.Uvarint(idx.blob[off:])
+	start := off + n
+	return idx.blob[start : start+int(l)]
+}
+
+// readField reads one uvarint-length-prefixed field from b, starting
+// at *pos, and advances *pos past it.
+func readField(b []byte, pos *int) []byte {
+	l, n := binary.Uvarint(b[*pos:])
+	*pos += n
+	v := b[*pos : *pos+int(l)]
+	*pos += int(l)
+	return v
+}
+
+type importReader struct {
+	g   *Graph
+	pkg *types.Package
+	idx *pkgIndex
+
+	typeCache map[int]types.Type
+	objCache  map[int]types.Object
+}
+
+func newImportReader(g *Graph, pkg *types.Package, idx *pkgIndex) *importReader {
+	return &importReader{
+		g:         g,
+		pkg:       pkg,
+		idx:       idx,
+		typeCache: map[int]types.Type{},
+		objCache:  map[int]types.Object{},
+	}
+}
+
+func (r *importReader) readObjectByName(name string) (types.Object, error) {
+	off, ok := r.idx.nameToOffset[name]
+	if !ok {
+		return nil, fmt.Errorf("obj: no such object %q in package %q", name, r.pkg.Path())
+	}
+	idx, ok := r.idx.offsetToIndex[off]
+	if !ok {
+		return nil, fmt.Errorf("obj: corrupt object index for %q in package %q", name, r.pkg.Path())
+	}
+	return r.readObjectAt(idx)
+}
+
+func (r *importReader) peekObjectName(idx int) string {
+	entry := r.idx.readLengthPrefixed(r.idx.objOffsets[idx])
+	pos := 0
+	nameID, _ := binary.Uvarint(readField(entry, &pos))
+	return r.idx.strs[nameID]
+}
+
+func (r *importReader) readObjectAt(idx int) (types.Object, error) {
+	if obj, ok := r.objCache[idx]; ok {
+		return obj, nil
+	}
+
+	entry := r.idx.readLengthPrefixed(r.idx.objOffsets[idx])
+	pos := 0
+	nameID, _ := binary.Uvarint(readField(entry, &pos))
+	name := r.idx.strs[nameID]
+	kind := readField(entry, &pos)[0]
+
+	if kind == kindTypename || kind == kindTypeParamName {
+		// A TypeName's Type() is the Named (or TypeParam) type it
+		// names, and that type's Obj() points right back here:
+		// decoding either side has to be able to hand the other a
+		// not-yet-complete value. Reserve the TypeName before
+		// touching its type so the reentrant call made from the
+		// bootstrap in readTypeAt (kindNamed or kindTypeParam) sees
+		// it instead of looping.
+		tname := types.NewTypeName(token.NoPos, r.pkg, name, nil)
+		r.objCache[idx] = tname
+		r.g.internObj(r.pkg.Path(), idx, tname)
+		typRef := readField(entry, &pos)
+		if _, err := r.decodeTypeRef(typRef); err != nil {
+			return nil, err
+		}
+		return tname, nil
+	}
+
+	typRef := readField(entry, &pos)
+	typ, err := r.decodeTypeRef(typRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj types.Object
+	switch kind {
+	case kindFunc:
+		sig, _ := typ.(*types.Signature)
+		obj = types.NewFunc(token.NoPos, r.pkg, name, sig)
+	case kindVar:
+		obj = types.NewVar(token.NoPos, r.pkg, name, typ)
+	case kindConst:
+		ckind := readField(entry, &pos)[0]
+		data := readField(entry, &pos)
+		val := decodeConstant(ckind, data)
+		obj = types.NewConst(token.NoPos, r.pkg, name, typ, val)
+	case kindPkgname:
+		path := readField(entry, &pos)
+		ipkg, err := r.g.foreignPackage(string(path))
+		if err != nil {
+			return nil, err
+		}
+		obj = types.NewPkgName(token.NoPos, r.pkg, name, ipkg)
+	default:
+		return nil, fmt.Errorf("obj: unknown object kind %d for %q", kind, name)
+	}
+
+	r.objCache[idx] = obj
+	r.g.internObj(r.pkg.Path(), idx, obj)
+	return obj, nil
+}
+
+func (r *importReader) readTypeAt(idx int) (types.Type, error) {
+	if t, ok := r.typeCache[idx]; ok {
+		return t, nil
+	}
+
+	entry := r.idx.readLengthPrefixed(r.idx.typeOffsets[idx])
+	pos := 0
+	kind := readField(entry, &pos)[0]
+
+	switch kind {
+	case kindSignature:
+		paramsRef := readField(entry, &pos)
+		resultsRef := readField(entry, &pos)
+		hasRecv := readField(entry, &pos)[0]
+		// The recv field is always present (possibly empty) since
+		// the writer emits it unconditionally; read it regardless
+		// of hasRecv to keep the cursor aligned with the fields
+		// that follow.
+		recvRef := readField(entry, &pos)
+		variadic := readField(entry, &pos)[0]
+		recvTParamsRef := readField(entry, &pos)
+		tParamsRef := readField(entry, &pos)
+
+		var recv *types.Var
+		if hasRecv == 1 {
+			recvIdx, _ := binary.Uvarint(recvRef)
+			robj, err := r.readObjectAt(int(recvIdx))
+			if err != nil {
+				return nil, err
+			}
+			recv, _ = robj.(*types.Var)
+		}
+
+		paramsT, err := r.decodeTypeRef(paramsRef)
+		if err != nil {
+			return nil, err
+		}
+		resultsT, err := r.decodeTypeRef(resultsRef)
+		if err != nil {
+			return nil, err
+		}
+		params, _ := paramsT.(*types.Tuple)
+		results, _ := resultsT.(*types.Tuple)
+
+		recvTParams, err := r.decodeTypeParamRefs(recvTParamsRef)
+		if err != nil {
+			return nil, err
+		}
+		tParams, err := r.decodeTypeParamRefs(tParamsRef)
+		if err != nil {
+			return nil, err
+		}
+
+		return types.NewSignatureType(recv, recvTParams, tParams, params, results, variadic == 1), nil
+
+	case kindNamed:
+		isInstance := readField(entry, &pos)[0]
+		if isInstance == 1 {
+			originRef := readField(entry, &pos)
+			origin, err := r.decodeTypeRef(originRef)
+			if err != nil {
+				return nil, err
+			}
+			numArgs, _ := binary.Uvarint(readField(entry, &pos))
+			targs := make([]types.Type, numArgs)
+			for i := range targs {
+				ref := readField(entry, &pos)
+				targs[i], err = r.decodeTypeRef(ref)
+				if err != nil {
+					return nil, err
+				}
+			}
+			inst, err := types.Instantiate(nil, origin, targs, false)
+			if err != nil {
+				return nil, err
+			}
+			r.typeCache[idx] = inst
+			r.g.internTyp(r.pkg.Path(), idx, inst)
+			return inst, nil
+		}
+
+		underlyingRef := readField(entry, &pos)
+		objRef := readField(entry, &pos)
+		objIdx, _ := binary.Uvarint(objRef)
+		tParamsRef := readField(entry, &pos)
+
+		tname, ok := r.objCache[int(objIdx)]
+		if !ok {
+			tn := types.NewTypeName(token.NoPos, r.pkg, r.peekObjectName(int(objIdx)), nil)
+			r.objCache[int(objIdx)] = tn
+			r.g.internObj(r.pkg.Path(), int(objIdx), tn)
+			tname = tn
+		}
+
+		named := types.NewNamed(tname.(*types.TypeName), nil, nil)
+		// Cache before resolving the underlying type: a recursive
+		// type (e.g. a struct holding a pointer to itself) refers
+		// back to this same dense index while we're still building
+		// it.
+		r.typeCache[idx] = named
+		r.g.internTyp(r.pkg.Path(), idx, named)
+
+		tParams, err := r.decodeTypeParamRefs(tParamsRef)
+		if err != nil {
+			return nil, err
+		}
+		if len(tParams) > 0 {
+			named.SetTypeParams(tParams)
+		}
+
+		underlying, err := r.decodeTypeRef(underlyingRef)
+		if err != nil {
+			return nil, err
+		}
+		named.SetUnderlying(underlying)
+
+		numMethods, _ := binary.Uvarint(readField(entry, &pos))
+		for i := 0; i < int(numMethods); i++ {
+			methodRef := readField(entry, &pos)
+			midx, _ := binary.Uvarint(methodRef)
+			mobj, err := r.readObjectAt(int(midx))
+			if err != nil {
+				return nil, err
+			}
+			if fn, ok := mobj.(*types.Func); ok {
+				named.AddMethod(fn)
+			}
+		}
+		return named, nil
+
+	case kindTypeParam:
+		// The recorded index is the parameter's position within its
+		// declaring list; we don't need it separately since
+		// SetTypeParams/NewSignatureType reconstruct the list (and
+		// therefore each parameter's index) from list order.
+		readField(entry, &pos)
+		constraintRef := readField(entry, &pos)
+		objRef := readField(entry, &pos)
+		objIdx, _ := binary.Uvarint(objRef)
+
+		tname, ok := r.objCache[int(objIdx)]
+		if !ok {
+			tn := types.NewTypeName(token.NoPos, r.pkg, r.peekObjectName(int(objIdx)), nil)
+			r.objCache[int(objIdx)] = tn
+			r.g.internObj(r.pkg.Path(), int(objIdx), tn)
+			tname = tn
+		}
+
+		tp := types.NewTypeParam(tname.(*types.TypeName), nil)
+		// Cache before resolving the constraint: a constraint can
+		// itself mention this same type parameter (e.g. a
+		// recursively bounded interface).
+		r.typeCache[idx] = tp
+		r.g.internTyp(r.pkg.Path(), idx, tp)
+
+		constraint, err := r.decodeTypeRef(constraintRef)
+		if err != nil {
+			return nil, err
+		}
+		tp.SetConstraint(constraint)
+		return tp, nil
+
+	case kindUnion:
+		numTerms, _ := binary.Uvarint(readField(entry, &pos))
+		terms := make([]*types.Term, numTerms)
+		for i := range terms {
+			tagField := readField(entry, &pos)
+			tilde := tagField[1] == 1
+			typRef := readField(entry, &pos)
+			t, err := r.decodeTypeRef(typRef)
+			if err != nil {
+				return nil, err
+			}
+			terms[i] = types.NewTerm(tilde, t)
+		}
+		return types.NewUnion(terms), nil
+
+	case kindSlice:
+		elemRef := readField(entry, &pos)
+		elem, err := r.decodeTypeRef(elemRef)
+		if err != nil {
+			return nil, err
+		}
+		t := types.NewSlice(elem)
+		r.typeCache[idx] = t
+		r.g.internTyp(r.pkg.Path(), idx, t)
+		return t, nil
+
+	case kindPointer:
+		elemRef := readField(entry, &pos)
+		elem, err := r.decodeTypeRef(elemRef)
+		if err != nil {
+			return nil, err
+		}
+		t := types.NewPointer(elem)
+		r.typeCache[idx] = t
+		r.g.internTyp(r.pkg.Path(), idx, t)
+		return t, nil
+
+	case kindInterface:
+		numExplicit, _ := binary.Uvarint(readField(entry, &pos))
+		methods := make([]*types.Func, 0, numExplicit)
+		for i := 0; i < int(numExplicit); i++ {
+			ref := readField(entry, &pos)
+			midx, _ := binary.Uvarint(ref)
+			mobj, err := r.readObjectAt(int(midx))
+			if err != nil {
+				return nil, err
+			}
+			fn, _ := mobj.(*types.Func)
+			methods = append(methods, fn)
+		}
+
+		numEmbeds, _ := binary.Uvarint(readField(entry, &pos))
+		embeds := make([]types.Type, 0, numEmbeds)
+		for i := 0; i < int(numEmbeds); i++ {
+			ref := readField(entry, &pos)
+			et, err := r.decodeTypeRef(ref)
+			if err != nil {
+				return nil, err
+			}
+			embeds = append(embeds, et)
+		}
+		return types.NewInterfaceType(methods, embeds), nil
+
+	case kindArray:
+		elemRef := readField(entry, &pos)
+		elem, err := r.decodeTypeRef(elemRef)
+		if err != nil {
+			return nil, err
+		}
+		length, _ := binary.Uvarint(readField(entry, &pos))
+		return types.NewArray(elem, int64(length)), nil
+
+	case kindStruct:
+		var fields []*types.Var
+		var tags []string
+		for pos < len(entry) {
+			ref := readField(entry, &pos)
+			tag := readField(entry, &pos)
+			fidx, _ := binary.Uvarint(ref)
+			fobj, err := r.readObjectAt(int(fidx))
+			if err != nil {
+				return nil, err
+			}
+			fv, _ := fobj.(*types.Var)
+			fields = append(fields, fv)
+			tags = append(tags, string(tag))
+		}
+		return types.NewStruct(fields, tags), nil
+
+	case kindTuple:
+		var vars []*types.Var
+		for pos < len(entry) {
+			ref := readField(entry, &pos)
+			vidx, _ := binary.Uvarint(ref)
+			vobj, err := r.readObjectAt(int(vidx))
+			if err != nil {
+				return nil, err
+			}
+			vv, _ := vobj.(*types.Var)
+			vars = append(vars, vv)
+		}
+		return types.NewTuple(vars...), nil
+
+	case kindMap:
+		keyRef := readField(entry, &pos)
+		elemRef := readField(entry, &pos)
+		key, err := r.decodeTypeRef(keyRef)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := r.decodeTypeRef(elemRef)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewMap(key, elem), nil
+
+	case kindChan:
+		elemRef := readField(entry, &pos)
+		dir := readField(entry, &pos)[0]
+		elem, err := r.decodeTypeRef(elemRef)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewChan(types.ChanDir(dir), elem), nil
+
+	default:
+		return nil, fmt.Errorf("obj: unknown type kind %d", kind)
+	}
+}
+
+// decodeTypeParamRefs decodes the nested (count, typeRef...) blob
+// produced by exportWriter.writeTypeParamRefs.
+func (r *importReader) decodeTypeParamRefs(blob []byte) ([]*types.TypeParam, error) {
+	pos := 0
+	n, _ := binary.Uvarint(readField(blob, &pos))
+	if n == 0 {
+		return nil, nil
+	}
+	out := make([]*types.TypeParam, n)
+	for i := range out {
+		ref := readField(blob, &pos)
+		t, err := r.decodeTypeRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		tp, ok := t.(*types.TypeParam)
+		if !ok {
+			return nil, fmt.Errorf("obj: expected type parameter, got %T", t)
+		}
+		out[i] = tp
+	}
+	return out, nil
+}
+
+func (r *importReader) decodeTypeRef(ref []byte) (types.Type, error) {
+	pos := 0
+	tag := readField(ref, &pos)[0]
+	switch tag {
+	case refBuiltin:
+		name := readField(ref, &pos)
+		b, ok := basicTypes[string(name)]
+		if !ok {
+			return nil, fmt.Errorf("obj: unknown builtin type %q", name)
+		}
+		return b, nil
+	case refLocal:
+		idxBytes := readField(ref, &pos)
+		idx, _ := binary.Uvarint(idxBytes)
+		return r.readTypeAt(int(idx))
+	case refForeign:
+		pkgIDBytes := readField(ref, &pos)
+		nameIDBytes := readField(ref, &pos)
+		pkgID, _ := binary.Uvarint(pkgIDBytes)
+		nameID, _ := binary.Uvarint(nameIDBytes)
+		return r.g.foreignType(r.idx.strs[pkgID], r.idx.strs[nameID])
+	case refUniverse:
+		name := readField(ref, &pos)
+		obj := types.Universe.Lookup(string(name))
+		if obj == nil {
+			return nil, fmt.Errorf("obj: unknown universe type %q", name)
+		}
+		return obj.Type(), nil
+	default:
+		return nil, fmt.Errorf("obj: unknown type reference tag %d", tag)
+	}
+}
+
+// foreignPackage and foreignType let a package's blob refer to
+// objects and types owned by another package's blob without
+// inlining them, resolving through the same Graph so the dependency
+// is loaded (and cached) at most once.
+func (g *Graph) foreignPackage(path string) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	return g.withPackageLatch(path, func() (*types.Package, error) {
+		return g.loadPackage(path)
+	})
+}
+
+func (g *Graph) foreignObject(pkgPath, name string) (types.Object, error) {
+	pkg, err := g.foreignPackage(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	if pkg == types.Unsafe {
+		return types.Universe.Lookup(name), nil
+	}
+	obj := pkg.Scope().Lookup(name)
+	if obj == nil {
+		return nil, fmt.Errorf("obj: package %q has no object %q", pkgPath, name)
+	}
+	return obj, nil
+}
+
+func (g *Graph) foreignType(pkgPath, name string) (types.Type, error) {
+	obj, err := g.foreignObject(pkgPath, name)
+	if err != nil {
+		return nil, err
+	}
+	tn, ok := obj.(*types.TypeName)
+	if !ok {
+		return nil, fmt.Errorf("obj: object %q in package %q is not a type", name, pkgPath)
+	}
+	return tn.Type(), nil
+}
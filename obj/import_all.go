@@ -0,0 +1,144 @@
+package obj
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// importNode is one package in the dependency DAG ImportAll builds
+// before scheduling any compilation, so that leaf packages (no
+// unresolved imports of their own) can be handed to a worker before
+// anything that depends on them.
+type importNode struct {
+	lp   *LoadedPackage
+	deps []string
+}
+
+// ImportAll compiles every package in paths, along with their
+// transitive dependencies, using a pool of GOMAXPROCS workers. It
+// first resolves the whole dependency DAG via g.loader, then releases
+// each package to the pool as soon as its own imports have finished,
+// so a fixed-size pool never wedges waiting on work it hasn't
+// scheduled yet. Two paths that share a dependency --- or two callers
+// racing ImportAll and ImportFrom for the same path --- coalesce onto
+// one compilation via the latch in withPackageLatch; ImportFrom
+// itself is responsible for persisting each package, guarding the
+// graph's shared tables with g.mu and flushing with
+// badger.KV.BatchSetAsync so the workers' writes don't serialize on
+// each other either.
+func (g *Graph) ImportAll(paths []string) error {
+	nodes, err := g.buildImportDAG(paths)
+	if err != nil {
+		return err
+	}
+
+	dependents := make(map[string][]string, len(nodes))
+	remaining := make(map[string]int, len(nodes))
+	for path, n := range nodes {
+		remaining[path] = len(n.deps)
+		for _, dep := range n.deps {
+			dependents[dep] = append(dependents[dep], path)
+		}
+	}
+
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	var schedule func(path string)
+	schedule = func(path string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			n := nodes[path]
+			_, err := g.ImportFrom(path, n.lp.Dir, 0)
+			<-sem
+
+			if err != nil {
+				once.Do(func() { firstErr = fmt.Errorf("obj: importing %q: %v", path, err) })
+			}
+
+			mu.Lock()
+			ready := make([]string, 0, len(dependents[path]))
+			for _, dep := range dependents[path] {
+				remaining[dep]--
+				if remaining[dep] == 0 {
+					ready = append(ready, dep)
+				}
+			}
+			mu.Unlock()
+
+			for _, dep := range ready {
+				schedule(dep)
+			}
+		}()
+	}
+
+	mu.Lock()
+	var leaves []string
+	for path, n := range remaining {
+		if n == 0 {
+			leaves = append(leaves, path)
+		}
+	}
+	mu.Unlock()
+	for _, path := range leaves {
+		schedule(path)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// buildImportDAG resolves paths and their transitive imports into a
+// map of importNode, using g.loader for metadata only --- the actual
+// compilation happens later, scheduled leaf-first by ImportAll.
+// Resolution itself runs serially: it's cheap relative to type
+// checking, and doing it up front gives ImportAll a complete picture
+// of the DAG before it commits any package to a worker.
+func (g *Graph) buildImportDAG(paths []string) (map[string]*importNode, error) {
+	nodes := map[string]*importNode{}
+
+	var visit func(path, srcDir string) error
+	visit = func(path, srcDir string) error {
+		if path == "unsafe" {
+			return nil
+		}
+		lp, err := g.loader.Load(g.Fset, path, srcDir)
+		if err != nil {
+			return fmt.Errorf("obj: resolving %q: %v", path, err)
+		}
+		if _, ok := nodes[lp.ImportPath]; ok {
+			return nil
+		}
+
+		deps := make([]string, 0, len(lp.Imports))
+		for _, imp := range lp.Imports {
+			if imp == "unsafe" {
+				continue
+			}
+			deps = append(deps, imp)
+		}
+		nodes[lp.ImportPath] = &importNode{lp: lp, deps: deps}
+
+		for _, imp := range lp.Imports {
+			if err := visit(imp, lp.Dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, path := range paths {
+		if err := visit(path, ""); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
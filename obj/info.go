@@ -0,0 +1,482 @@
+package obj
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/dgraph-io/badger"
+)
+
+// This file extends the package blob with a second, sibling blob
+// (pkgs/<path>\x00info) that persists the type checker's *types.Info
+// and enough of each file's source to reparse its AST, so that
+// analyses which need positions, selections, or implicit objects
+// don't have to recompile the package from scratch every time.
+//
+// Positions are stored as (file index, byte offset, occurrence
+// index) triples rather than raw token.Pos values, since a token.Pos is only meaningful
+// relative to the token.FileSet it was minted from, and PackageInfo
+// reparses each file into a fresh FileSet rather than trying to
+// replay the original one.
+
+// encodeInfo encodes files' source (for later reparsing) and info
+// using w, the same exportWriter used for the package's object/type
+// blob, so that Info's objects and types are interned exactly once
+// and referenced by the same dense indices. It returns the info blob,
+// to be stored alongside the blob produced by w.finish().
+func (w *exportWriter) encodeInfo(fset *token.FileSet, files []*ast.File, info *types.Info) ([]byte, error) {
+	fileIdx := map[string]int{}
+	var buf bytes.Buffer
+
+	buf.Write(uvarintBytes(uint64(len(files))))
+	for i, f := range files {
+		name := fset.Position(f.Pos()).Filename
+		fileIdx[name] = i
+
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(src)
+
+		buf.Write(uvarintBytes(uint64(w.stringIndex(name))))
+		buf.Write(uvarintBytes(uint64(len(src))))
+		buf.Write(src)
+		buf.Write(sum[:])
+	}
+
+	// occIndex disambiguates entries whose node shares a token.Pos with
+	// other nodes --- an ordinary occurrence in Go syntax, since
+	// *ast.CallExpr, *ast.SelectorExpr, *ast.IndexExpr, *ast.BinaryExpr,
+	// and *ast.TypeAssertExpr (among others) all report their leftmost
+	// operand's position as their own. Both this writer and
+	// decodePackageInfo visit every file with the same ast.Inspect
+	// traversal (pre-order, so an outer node is always counted before
+	// the children that share its Pos) and assign a 0-based occurrence
+	// count per token.Pos; storing that alongside the position lets the
+	// reader recover the exact node an entry was recorded against,
+	// instead of every entry at that offset colliding onto whichever
+	// node ast.Inspect happens to visit last.
+	occIndex := map[ast.Node]int{}
+	posSeen := map[token.Pos]int{}
+	for _, f := range files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			if n == nil {
+				return true
+			}
+			p := n.Pos()
+			occIndex[n] = posSeen[p]
+			posSeen[p]++
+			return true
+		})
+	}
+
+	posRef := func(node ast.Node) ([]byte, bool) {
+		p := fset.Position(node.Pos())
+		idx, ok := fileIdx[p.Filename]
+		if !ok {
+			return nil, false
+		}
+		return encodeBytes(uvarintBytes(uint64(idx)), uvarintBytes(uint64(p.Offset)), uvarintBytes(uint64(occIndex[node]))), true
+	}
+
+	writeIdentMap := func(m map[*ast.Ident]types.Object) {
+		var entries [][]byte
+		for id, obj := range m {
+			if obj == nil {
+				continue
+			}
+			ref, ok := posRef(id)
+			if !ok {
+				continue
+			}
+			entries = append(entries, encodeBytes(ref, w.writeObjRef(obj)))
+		}
+		buf.Write(uvarintBytes(uint64(len(entries))))
+		for _, e := range entries {
+			buf.Write(e)
+		}
+	}
+
+	writeIdentMap(info.Defs)
+	writeIdentMap(info.Uses)
+
+	var implicits [][]byte
+	for node, obj := range info.Implicits {
+		if obj == nil {
+			continue
+		}
+		ref, ok := posRef(node)
+		if !ok {
+			continue
+		}
+		implicits = append(implicits, encodeBytes(ref, w.writeObjRef(obj)))
+	}
+	buf.Write(uvarintBytes(uint64(len(implicits))))
+	for _, e := range implicits {
+		buf.Write(e)
+	}
+
+	var sels [][]byte
+	for expr, sel := range info.Selections {
+		ref, ok := posRef(expr)
+		if !ok {
+			continue
+		}
+		indirect := byte(0)
+		if sel.Indirect() {
+			indirect = 1
+		}
+		index := sel.Index()
+		idxEntries := [][]byte{uvarintBytes(uint64(len(index)))}
+		for _, i := range index {
+			idxEntries = append(idxEntries, uvarintBytes(uint64(i)))
+		}
+		sels = append(sels, encodeBytes(
+			ref,
+			[]byte{byte(sel.Kind())},
+			w.writeObjRef(sel.Obj()),
+			w.writeTypeRef(sel.Type()),
+			[]byte{indirect},
+			encodeBytes(idxEntries...),
+		))
+	}
+	buf.Write(uvarintBytes(uint64(len(sels))))
+	for _, e := range sels {
+		buf.Write(e)
+	}
+
+	var typesEntries [][]byte
+	for expr, tv := range info.Types {
+		ref, ok := posRef(expr)
+		if !ok {
+			continue
+		}
+		hasValue := byte(0)
+		var ckind byte
+		var cdata []byte
+		if tv.Value != nil {
+			hasValue = 1
+			ckind, cdata = encodeConstant(reflect.ValueOf(tv.Value))
+		}
+		typesEntries = append(typesEntries, encodeBytes(
+			ref,
+			w.writeTypeRef(tv.Type),
+			[]byte{hasValue},
+			[]byte{ckind},
+			cdata,
+		))
+	}
+	buf.Write(uvarintBytes(uint64(len(typesEntries))))
+	for _, e := range typesEntries {
+		buf.Write(e)
+	}
+
+	// Scopes are recorded flat (node position -> the names declared
+	// directly in that scope), not as the full parent/child tree:
+	// any code that needs a particular scope can ask for it by the
+	// position of the node that introduces it, and the objects
+	// named there are already in the object section like any other
+	// object.
+	var scopeEntries [][]byte
+	for node, scope := range info.Scopes {
+		ref, ok := posRef(node)
+		if !ok {
+			continue
+		}
+		names := scope.Names()
+		var nameRefs [][]byte
+		nameRefs = append(nameRefs, uvarintBytes(uint64(len(names))))
+		for _, name := range names {
+			obj := scope.Lookup(name)
+			nameRefs = append(nameRefs, w.writeObjRef(obj))
+		}
+		scopeEntries = append(scopeEntries, encodeBytes(ref, encodeBytes(nameRefs...)))
+	}
+	buf.Write(uvarintBytes(uint64(len(scopeEntries))))
+	for _, e := range scopeEntries {
+		buf.Write(e)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// PackageInfo returns the type checker's *types.Info and the parsed
+// *ast.Package for path, reparsing its files from the source bytes
+// recorded at insertion time. For a package compiled earlier in this
+// process it returns the original, still-live values; for one loaded
+// from the graph it reparses the stored sources against a fresh
+// token.FileSet and rebuilds Info by resolving the positions and
+// object/type references recorded in the pkgs/<path>\x00info blob.
+//
+// Info.Selections is not rebuilt: go/types exposes no public
+// constructor for *types.Selection, so a selection read back from the
+// graph can't be rebuilt byte-for-byte. For a package loaded from the
+// graph (i.e. every call except the one immediately after that
+// package was compiled live in this process), info.Selections is
+// always empty; callers that need a selector's resolved object can
+// still get it from info.Uses, keyed by the same *ast.Ident. Two
+// other maps are rebuilt only in part: each Info.Types entry carries
+// its Type and Value but not its mode, so IsType, IsValue and the
+// other mode predicates all report false, and each Info.Scopes entry
+// is a flat scope with no parent, children or position.
+func (g *Graph) PackageInfo(path string) (*ast.Package, *types.Info, error) {
+	g.mu.Lock()
+	info, isLive := g.liveInfo[path]
+	livePkg := g.livePackages[path]
+	_, loaded := g.pkgs[path]
+	g.mu.Unlock()
+	if isLive {
+		return livePkg, info, nil
+	}
+
+	if !loaded {
+		if _, err := g.withPackageLatch(path, func() (*types.Package, error) {
+			return g.loadPackage(path)
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+	g.mu.Lock()
+	r, ok := g.readers[path]
+	g.mu.Unlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("obj: no import reader cached for %q", path)
+	}
+
+	var item badger.KVItem
+	if err := g.kv.Get([]byte(fmt.Sprintf("pkgs/%s\x00info", path)), &item); err != nil {
+		return nil, nil, err
+	}
+	blob, err := kvItemValue(&item)
+	if err != nil {
+		return nil, nil, err
+	}
+	if blob == nil {
+		return nil, nil, fmt.Errorf("obj: no info recorded for %q", path)
+	}
+
+	return decodePackageInfo(r, blob)
+}
+
+func decodePackageInfo(r *importReader, blob []byte) (*ast.Package, *types.Info, error) {
+	pos := 0
+	// The file table and the entry counts below were all written with
+	// plain buf.Write(uvarintBytes(...)) rather than through
+	// encodeBytes, so they're read back the same way newPkgIndex reads
+	// its own header: as bare uvarints advancing pos directly, not as
+	// length-prefixed fields via readField.
+	readUvarint := func() uint64 {
+		v, n := binary.Uvarint(blob[pos:])
+		pos += n
+		return v
+	}
+
+	numFiles := int(readUvarint())
+	fset := token.NewFileSet()
+	astFiles := make([]*ast.File, numFiles)
+	// nodesByPos holds every node sharing a given token.Pos, in the
+	// same pre-order ast.Inspect visits them in --- an outer node
+	// (*ast.CallExpr, *ast.SelectorExpr, ...) always before the
+	// leftmost child whose own Pos it reports. encodeInfo built its
+	// occurrence indices with the identical traversal over the
+	// identical source, so indexing into this slice by that count
+	// recovers the exact node an entry was recorded against.
+	nodesByPos := make(map[token.Pos][]ast.Node)
+	tokenFiles := make([]*token.File, numFiles)
+
+	for i := range astFiles {
+		nameID := readUvarint()
+		name := r.idx.strs[nameID]
+		srcLen := int(readUvarint())
+		src := blob[pos : pos+srcLen]
+		pos += srcLen
+		pos += sha256.Size // checksum is for independent AST-cache invalidation; not needed to reparse
+
+		f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+		if err != nil {
+			return nil, nil, err
+		}
+		astFiles[i] = f
+		tokenFiles[i] = fset.File(f.Pos())
+		ast.Inspect(f, func(n ast.Node) bool {
+			if n != nil {
+				p := n.Pos()
+				nodesByPos[p] = append(nodesByPos[p], n)
+			}
+			return true
+		})
+	}
+
+	nodeAt := func(ref []byte) (ast.Node, bool) {
+		rpos := 0
+		fileIdx, _ := binary.Uvarint(readField(ref, &rpos))
+		offset, _ := binary.Uvarint(readField(ref, &rpos))
+		occ, _ := binary.Uvarint(readField(ref, &rpos))
+		if int(fileIdx) >= len(tokenFiles) {
+			return nil, false
+		}
+		p := tokenFiles[fileIdx].Pos(int(offset))
+		nodes := nodesByPos[p]
+		if int(occ) >= len(nodes) {
+			return nil, false
+		}
+		return nodes[occ], true
+	}
+
+	info := &types.Info{
+		Defs:       map[*ast.Ident]types.Object{},
+		Uses:       map[*ast.Ident]types.Object{},
+		Implicits:  map[ast.Node]types.Object{},
+		Selections: map[*ast.SelectorExpr]*types.Selection{},
+		Types:      map[ast.Expr]types.TypeAndValue{},
+		Scopes:     map[ast.Node]*types.Scope{},
+	}
+
+	readIdentMap := func(m map[*ast.Ident]types.Object) error {
+		n := int(readUvarint())
+		for i := 0; i < n; i++ {
+			ref := readField(blob, &pos)
+			objRef := readField(blob, &pos)
+			node, ok := nodeAt(ref)
+			if !ok {
+				continue
+			}
+			id, ok := node.(*ast.Ident)
+			if !ok {
+				continue
+			}
+			idx, _ := binary.Uvarint(objRef)
+			obj, err := r.readObjectAt(int(idx))
+			if err != nil {
+				return err
+			}
+			m[id] = obj
+		}
+		return nil
+	}
+	if err := readIdentMap(info.Defs); err != nil {
+		return nil, nil, err
+	}
+	if err := readIdentMap(info.Uses); err != nil {
+		return nil, nil, err
+	}
+
+	numImplicits := int(readUvarint())
+	for i := 0; i < numImplicits; i++ {
+		ref := readField(blob, &pos)
+		objRef := readField(blob, &pos)
+		node, ok := nodeAt(ref)
+		if !ok {
+			continue
+		}
+		idx, _ := binary.Uvarint(objRef)
+		obj, err := r.readObjectAt(int(idx))
+		if err != nil {
+			return nil, nil, err
+		}
+		info.Implicits[node] = obj
+	}
+
+	numSels := int(readUvarint())
+	for i := 0; i < numSels; i++ {
+		ref := readField(blob, &pos)
+		_ = readField(blob, &pos) // kind; Selection is reconstructed generically below
+		objRef := readField(blob, &pos)
+		_ = readField(blob, &pos) // type ref (unused: selection type is derived from obj below)
+		_ = readField(blob, &pos) // indirect
+		_ = readField(blob, &pos) // index path
+
+		node, ok := nodeAt(ref)
+		if !ok {
+			continue
+		}
+		sel, ok := node.(*ast.SelectorExpr)
+		if !ok {
+			continue
+		}
+		idx, _ := binary.Uvarint(objRef)
+		if _, err := r.readObjectAt(int(idx)); err != nil {
+			return nil, nil, err
+		}
+		// go/types offers no public Selection constructor, so a
+		// decoded Selections map can't be rebuilt to a byte-for-byte
+		// copy of the original; the selector's resolved object is
+		// already available via Uses, so there's nothing more to
+		// recover here.
+		_ = sel
+	}
+
+	numTypes := int(readUvarint())
+	for i := 0; i < numTypes; i++ {
+		ref := readField(blob, &pos)
+		typRef := readField(blob, &pos)
+		hasValue := readField(blob, &pos)[0]
+		ckind := readField(blob, &pos)[0]
+		cdata := readField(blob, &pos)
+
+		node, ok := nodeAt(ref)
+		if !ok {
+			continue
+		}
+		expr, ok := node.(ast.Expr)
+		if !ok {
+			continue
+		}
+		typ, err := r.decodeTypeRef(typRef)
+		if err != nil {
+			return nil, nil, err
+		}
+		var tv types.TypeAndValue
+		tv.Type = typ
+		if hasValue == 1 {
+			tv.Value = decodeConstant(ckind, cdata)
+		}
+		info.Types[expr] = tv
+	}
+
+	numScopes := int(readUvarint())
+	for i := 0; i < numScopes; i++ {
+		ref := readField(blob, &pos)
+		namesBlob := readField(blob, &pos)
+
+		node, ok := nodeAt(ref)
+		if !ok {
+			continue
+		}
+
+		npos := 0
+		count, _ := binary.Uvarint(readField(namesBlob, &npos))
+		scope := types.NewScope(nil, token.NoPos, token.NoPos, "")
+		for j := uint64(0); j < count; j++ {
+			objRef := readField(namesBlob, &npos)
+			idx, _ := binary.Uvarint(objRef)
+			obj, err := r.readObjectAt(int(idx))
+			if err != nil {
+				return nil, nil, err
+			}
+			scope.Insert(obj)
+		}
+		info.Scopes[node] = scope
+	}
+
+	pkgFiles := make(map[string]*ast.File, len(astFiles))
+	for _, f := range astFiles {
+		name := fset.Position(f.Pos()).Filename
+		pkgFiles[name] = f
+	}
+	apkg := &ast.Package{
+		Name:  r.pkg.Name(),
+		Files: pkgFiles,
+	}
+
+	return apkg, info, nil
+}
@@ -0,0 +1,189 @@
+package obj
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/packages"
+	"honnef.co/go/tools/obj/cgo"
+)
+
+// Options configures how a Graph resolves import paths to packages.
+// A zero Options uses the process's own environment and working
+// directory, which is normally what's wanted.
+type Options struct {
+	// Env overrides the environment the package loader runs `go list`
+	// in (e.g. to pin GOFLAGS or GO111MODULE). Nil means inherit the
+	// process's environment.
+	Env []string
+
+	// BuildFlags are passed through to the underlying `go list`/`go
+	// build` invocations, e.g. []string{"-tags=integration"}.
+	BuildFlags []string
+
+	// Dir is the directory packages are resolved relative to when a
+	// caller doesn't supply its own srcDir. Defaults to the process's
+	// current directory.
+	Dir string
+}
+
+// LoadedPackage is the result of resolving a single import path: its
+// build metadata, already-parsed syntax, and the import paths it
+// depends on, so callers don't need to know whether that came from
+// `go/build` or `go/packages`.
+type LoadedPackage struct {
+	ImportPath string
+	ModulePath string // empty outside of module mode
+	Dir        string
+	Name       string
+
+	GoFiles  []string
+	CgoFiles []string
+	Imports  []string
+
+	Files []*ast.File
+}
+
+// PackageLoader resolves an import path (relative to srcDir) into its
+// metadata and parsed syntax. Graph uses it instead of calling
+// go/build directly, so that it can be backed by something that
+// understands modules, vendoring, and workspaces.
+type PackageLoader interface {
+	Load(fset *token.FileSet, path, srcDir string) (*LoadedPackage, error)
+}
+
+// newDefaultLoader returns the PackageLoader a freshly opened Graph
+// uses: packages.Load when it can resolve path (giving correct
+// behavior under modules, vendor directories, and workspaces), falling
+// back to the plain go/build importer for callers with no module
+// context (e.g. a lone GOPATH package with no go.mod in reach).
+func newDefaultLoader(buildCtx build.Context, opts *Options) PackageLoader {
+	return &combinedLoader{
+		primary:  newPackagesLoader(opts),
+		fallback: &buildLoader{build: buildCtx},
+	}
+}
+
+type combinedLoader struct {
+	primary  PackageLoader
+	fallback PackageLoader
+}
+
+func (l *combinedLoader) Load(fset *token.FileSet, path, srcDir string) (*LoadedPackage, error) {
+	lp, err := l.primary.Load(fset, path, srcDir)
+	if err == nil {
+		return lp, nil
+	}
+	return l.fallback.Load(fset, path, srcDir)
+}
+
+// packagesLoader is the default, module-aware PackageLoader, backed
+// by golang.org/x/tools/go/packages.
+type packagesLoader struct {
+	env        []string
+	buildFlags []string
+	dir        string
+}
+
+func newPackagesLoader(opts *Options) *packagesLoader {
+	l := &packagesLoader{}
+	if opts != nil {
+		l.env = opts.Env
+		l.buildFlags = opts.BuildFlags
+		l.dir = opts.Dir
+	}
+	return l
+}
+
+func (l *packagesLoader) Load(fset *token.FileSet, path, srcDir string) (*LoadedPackage, error) {
+	dir := srcDir
+	if dir == "" {
+		dir = l.dir
+	}
+	cfg := &packages.Config{
+		Mode:       packages.LoadImports | packages.LoadFiles | packages.LoadSyntax,
+		Dir:        dir,
+		Env:        l.env,
+		BuildFlags: l.buildFlags,
+		Fset:       fset,
+	}
+	pkgs, err := packages.Load(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("obj: packages.Load found no package for %q", path)
+	}
+	pkg := pkgs[0]
+	if len(pkg.Errors) > 0 {
+		return nil, fmt.Errorf("obj: loading %q: %v", path, pkg.Errors[0])
+	}
+
+	lp := &LoadedPackage{
+		ImportPath: pkg.PkgPath,
+		Name:       pkg.Name,
+		Files:      pkg.Syntax,
+	}
+	if pkg.Module != nil {
+		lp.ModulePath = pkg.Module.Path
+	}
+	if len(pkg.GoFiles) > 0 {
+		lp.Dir = filepath.Dir(pkg.GoFiles[0])
+	}
+	for _, f := range pkg.GoFiles {
+		lp.GoFiles = append(lp.GoFiles, filepath.Base(f))
+	}
+	for imp := range pkg.Imports {
+		lp.Imports = append(lp.Imports, imp)
+	}
+	sort.Strings(lp.Imports)
+
+	return lp, nil
+}
+
+// buildLoader is the plain go/build PackageLoader, kept as a fallback
+// for callers with no module context for packages.Load to work with.
+// It's the same resolution ImportFrom used before modules support was
+// added.
+type buildLoader struct {
+	build build.Context
+}
+
+func (l *buildLoader) Load(fset *token.FileSet, path, srcDir string) (*LoadedPackage, error) {
+	bpkg, err := l.build.Import(path, srcDir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*ast.File
+	for _, f := range bpkg.GoFiles {
+		af, err := buildutil.ParseFile(fset, &l.build, nil, bpkg.Dir, f, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, af)
+	}
+	if len(bpkg.CgoFiles) > 0 {
+		cgoFiles, err := cgo.ProcessCgoFiles(bpkg, fset, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, cgoFiles...)
+	}
+
+	return &LoadedPackage{
+		ImportPath: bpkg.ImportPath,
+		Dir:        bpkg.Dir,
+		Name:       bpkg.Name,
+		GoFiles:    bpkg.GoFiles,
+		CgoFiles:   bpkg.CgoFiles,
+		Imports:    bpkg.Imports,
+		Files:      files,
+	}, nil
+}